@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/gmail"
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+	"github.com/janyksteenbeek/birdgpt/internal/oauth"
+	"golang.org/x/oauth2"
+)
+
+func runAuth(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing auth target\n%s", usage)
+	}
+
+	target, args := args[0], args[1:]
+
+	flags := flag.NewFlagSet("auth "+target, flag.ExitOnError)
+	headless := flags.Bool("headless", false, "print the authorization URL and read the redirected URL from stdin instead of listening for the callback")
+	redirectURL := flags.String("redirect-url", "", "loopback redirect URL to listen on (gmail defaults to a random port on 127.0.0.1, moneybird to moneybird.redirect_uri)")
+	selectAdmin := flags.Bool("select-administration", false, "moneybird: choose the administration even when admin_id is already set")
+	timeout := flags.Duration("timeout", 5*time.Minute, "how long to wait for authorization")
+	flags.Parse(args)
+
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+
+	opts := oauth.AuthorizeOptions{
+		RedirectURL: *redirectURL,
+		Headless:    *headless,
+		Timeout:     *timeout,
+		Out:         os.Stdout,
+		In:          os.Stdin,
+	}
+
+	switch target {
+	case "gmail":
+		if cfg.Gmail.CredentialsFile == "" {
+			return fmt.Errorf("gmail credentials_file is required")
+		}
+		if err := gmail.Authorize(ctx, cfg.Gmail.CredentialsFile, cfg.Gmail.TokenFile, cfg.Gmail.Labels.Enabled, opts); err != nil {
+			return fmt.Errorf("gmail authorization failed: %w", err)
+		}
+		log.Printf("Gmail authorized, token saved to %s", cfg.Gmail.TokenFile)
+	case "moneybird":
+		if cfg.Moneybird.ClientID == "" || cfg.Moneybird.ClientSecret == "" {
+			return fmt.Errorf("moneybird client_id and client_secret are required")
+		}
+		if opts.RedirectURL == "" && cfg.Moneybird.RedirectURI == "" {
+			return fmt.Errorf("moneybird redirect_uri is required")
+		}
+		oauthConfig := moneybirdOAuthConfig(cfg)
+		if err := moneybird.Authorize(ctx, oauthConfig, cfg.Moneybird.TokenFile, opts); err != nil {
+			return fmt.Errorf("moneybird authorization failed: %w", err)
+		}
+		log.Printf("Moneybird authorized, token saved to %s", cfg.Moneybird.TokenFile)
+
+		if cfg.Moneybird.AdminID == "" || *selectAdmin {
+			if err := chooseAdministration(ctx, cfg, oauthConfig); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown auth target %q\n%s", target, usage)
+	}
+
+	return nil
+}
+
+func moneybirdOAuthConfig(cfg *config.Config) *oauth2.Config {
+	return moneybird.OAuthConfig(cfg.Moneybird.ClientID, cfg.Moneybird.ClientSecret, cfg.Moneybird.RedirectURI)
+}
+
+func chooseAdministration(ctx context.Context, cfg *config.Config, oauthConfig *oauth2.Config) error {
+	httpClient, err := moneybird.NewHTTPClient(ctx, oauthConfig, "", cfg.Moneybird.TokenFile)
+	if err != nil {
+		return err
+	}
+
+	administrations, err := moneybird.ListAdministrations(httpClient)
+	if err != nil {
+		return fmt.Errorf("listing administrations: %w", err)
+	}
+
+	var chosen moneybird.Administration
+	switch len(administrations) {
+	case 0:
+		return fmt.Errorf("the token cannot access any administration")
+	case 1:
+		chosen = administrations[0]
+	default:
+		fmt.Println("Administrations available to this token:")
+		for i, admin := range administrations {
+			fmt.Printf("  %d) %s (%s)\n", i+1, admin.Name, admin.ID)
+		}
+		fmt.Print("Choose an administration: ")
+
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Scan()
+		n, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil || n < 1 || n > len(administrations) {
+			return fmt.Errorf("invalid choice: %q", scanner.Text())
+		}
+		chosen = administrations[n-1]
+	}
+
+	cfg.Moneybird.AdminID = chosen.ID
+	if err := config.SaveConfig(cfg); err != nil {
+		return fmt.Errorf("saving config: %w", err)
+	}
+
+	log.Printf("Using administration %s (%s)", chosen.Name, chosen.ID)
+	return nil
+}
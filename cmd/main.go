@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"log"
@@ -11,52 +10,174 @@ import (
 	"time"
 
 	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/extraction/anthropic"
+	"github.com/janyksteenbeek/birdgpt/internal/extraction/openai"
+	"github.com/janyksteenbeek/birdgpt/internal/fx"
 	"github.com/janyksteenbeek/birdgpt/internal/gmail"
 	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
-	"github.com/janyksteenbeek/birdgpt/internal/openai"
 	"github.com/janyksteenbeek/birdgpt/internal/processor"
+	"github.com/janyksteenbeek/birdgpt/internal/rules"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+	"github.com/janyksteenbeek/birdgpt/internal/tax"
 )
 
+const usage = `Usage:
+  birdgpt [run]            Start the invoice processor
+  birdgpt auth gmail       Authorize Gmail access
+  birdgpt auth moneybird   Authorize Moneybird access and pick the administration
+  birdgpt queue list       Show failed, dead-lettered and review emails (-status to filter)
+  birdgpt queue requeue    Retry emails by message ID, or -all-dead (-contact to pin the contact,
+                           -approve to book emails a vendor rule flagged for review,
+                           -approve-vat <reference or #n> to book a document at its extracted VAT rates,
+                           -reextract to ask the model again)`
+
 func main() {
 	log.Println("[github.com/janyksteenbeek/birdgpt]")
-	log.Println("Starting invoice processor...")
 
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatalf("Configuration error: %v", err)
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	setupGracefulShutdown(cancel)
 
+	var err error
+	switch command {
+	case "run":
+		err = run(ctx)
+	case "auth":
+		err = runAuth(ctx, args)
+	case "queue":
+		err = runQueue(args)
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context) error {
+	log.Println("Starting invoice processor...")
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+
+	st, err := store.Open(cfg.App.StateFile)
+	if err != nil {
+		return fmt.Errorf("state initialization failed: %w", err)
+	}
+	defer st.Close()
+
+	vendorRules, err := rules.Load(cfg.App.RulesFile)
+	if err != nil {
+		return fmt.Errorf("rules initialization failed: %w", err)
+	}
+	log.Printf("Loaded %d vendor rule(s)", len(vendorRules.Rules))
+
+	rates, err := newRateProvider(cfg)
+	if err != nil {
+		return fmt.Errorf("exchange rates initialization failed: %w", err)
+	}
+
 	log.Println("Initializing clients...")
-	gmailClient, err := initializeGmail(ctx, cfg)
+	gmailClient, err := gmail.Setup(ctx, cfg.Gmail.CredentialsFile, cfg.Gmail.TokenFile, cfg.Gmail.Labels.Enabled)
 	if err != nil {
-		log.Fatalf("Gmail initialization failed: %v", err)
+		return fmt.Errorf("gmail initialization failed: %w", err)
 	}
 
-	moneybirdClient, err := moneybird.NewClient(cfg.Moneybird.Token, cfg.Moneybird.AdminID)
+	moneybirdHTTP, err := moneybird.NewHTTPClient(ctx, moneybirdOAuthConfig(cfg), cfg.Moneybird.Token, cfg.Moneybird.TokenFile)
 	if err != nil {
-		log.Fatalf("Moneybird initialization failed: %v", err)
+		return fmt.Errorf("moneybird initialization failed: %w", err)
+	}
+	if cfg.Moneybird.Token != "" {
+		log.Println("Using Moneybird personal token, consider switching to OAuth with `birdgpt auth moneybird`")
 	}
 
-	openaiClient := openai.NewClient(cfg.OpenAI.APIKey)
+	moneybirdClient, err := moneybird.NewClient(moneybirdHTTP, cfg.Moneybird.AdminID)
+	if err != nil {
+		return fmt.Errorf("moneybird initialization failed: %w", err)
+	}
+
+	taxRates, err := tax.NewRates(moneybirdClient.TaxRates(), cfg.Moneybird.TaxRates)
+	if err != nil {
+		return fmt.Errorf("tax rates initialization failed: %w", err)
+	}
+	classifier, err := tax.NewClassifier(cfg.Moneybird.Country, taxRates)
+	if err != nil {
+		return fmt.Errorf("tax rates initialization failed: %w", err)
+	}
+
+	extractor := newExtractor(cfg, st)
 
 	log.Println("Testing connections...")
 	if err := testConnections(ctx, cfg, gmailClient, moneybirdClient); err != nil {
-		log.Fatalf("Connection test failed: %v", err)
+		return fmt.Errorf("connection test failed: %w", err)
 	}
 
-	proc := processor.New(cfg, gmailClient, moneybirdClient, openaiClient)
+	proc := processor.New(cfg, st, vendorRules, rates, classifier, gmailClient, moneybirdClient, extractor)
 	log.Printf("Invoice processor started. Checking for new emails every %v...", cfg.App.SleepTime)
 	if err := proc.Run(ctx); err != nil && err != context.Canceled {
-		log.Fatalf("Processor error: %v", err)
+		return fmt.Errorf("processor error: %w", err)
+	}
+
+	return nil
+}
+
+// newExtractor creates the extraction client for the configured provider.
+// Local servers need no API key but most OpenAI-compatible servers still
+// expect the header, so a placeholder is sent.
+func newExtractor(cfg *config.Config, st *store.Store) extraction.Extractor {
+	var ocr extraction.OCR
+	if cfg.OCR.Enabled {
+		// OCR results are kept in the ledger, so retries do not run it again.
+		ocr = extraction.NewCachedOCR(extraction.NewTesseract(cfg.OCR.Languages), st, cfg.OCR.Languages)
+	}
+
+	llm := cfg.LLM
+	switch llm.Provider {
+	case "anthropic":
+		return anthropic.NewClient(anthropic.Options{APIKey: llm.APIKey, Model: llm.Model, BaseURL: llm.BaseURL, Vision: llm.Vision, OCR: ocr})
+	case "azure":
+		return openai.NewClient(openai.Options{APIKey: llm.APIKey, Model: llm.Model, BaseURL: llm.BaseURL, Azure: true, APIVersion: llm.APIVersion, Vision: llm.Vision, OCR: ocr})
+	case "ollama":
+		baseURL, apiKey := llm.BaseURL, llm.APIKey
+		if baseURL == "" {
+			baseURL = "http://localhost:11434/v1"
+		}
+		if apiKey == "" {
+			apiKey = "ollama"
+		}
+		return openai.NewClient(openai.Options{APIKey: apiKey, Model: llm.Model, BaseURL: baseURL, Vision: llm.Vision, OCR: ocr})
+	default:
+		return openai.NewClient(openai.Options{APIKey: llm.APIKey, Model: llm.Model, BaseURL: llm.BaseURL, Vision: llm.Vision, OCR: ocr})
+	}
+}
+
+func newRateProvider(cfg *config.Config) (fx.Provider, error) {
+	switch cfg.FX.Provider {
+	case "static":
+		return fx.LoadStatic(cfg.FX.File)
+	case "ecb":
+		return fx.NewECB(cfg.FX.URL), nil
+	default:
+		return nil, nil
 	}
 }
 
 func testConnections(ctx context.Context, cfg *config.Config, gmail *gmail.Client, moneybird *moneybird.Client) error {
-	if _, err := gmail.FetchEmails(ctx, cfg.Gmail.SearchLabel, time.Now().Add(-time.Minute)); err != nil {
+	query := fmt.Sprintf("label:%s after:%d", cfg.Gmail.SearchLabel, time.Now().Add(-time.Minute).Unix())
+	if _, err := gmail.ListMessageIDs(ctx, query); err != nil {
 		return fmt.Errorf("gmail test failed: %w", err)
 	}
 
@@ -77,32 +198,3 @@ func setupGracefulShutdown(cancel context.CancelFunc) {
 		cancel()
 	}()
 }
-
-func initializeGmail(ctx context.Context, cfg *config.Config) (*gmail.Client, error) {
-	client, authURL, err := gmail.Setup(ctx, cfg.Gmail.CredentialsFile, cfg.Gmail.Token)
-	if err != nil {
-		return nil, fmt.Errorf("gmail setup failed: %w", err)
-	}
-
-	if authURL != "" {
-		fmt.Printf("Visit this URL to authorize Gmail access:\n%s\n", authURL)
-		fmt.Print("Enter the authorization code: ")
-
-		scanner := bufio.NewScanner(os.Stdin)
-		scanner.Scan()
-
-		token, err := gmail.Exchange(ctx, cfg.Gmail.CredentialsFile, scanner.Text())
-		if err != nil {
-			return nil, fmt.Errorf("token exchange failed: %w", err)
-		}
-
-		cfg.Gmail.Token = token
-		if err := config.SaveConfig(cfg); err != nil {
-			return nil, fmt.Errorf("saving config: %w", err)
-		}
-
-		return initializeGmail(ctx, cfg)
-	}
-
-	return client, nil
-}
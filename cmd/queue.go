@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+)
+
+func runQueue(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing queue command\n%s", usage)
+	}
+
+	command, args := args[0], args[1:]
+
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		return fmt.Errorf("configuration error: %w", err)
+	}
+
+	st, err := store.Open(cfg.App.StateFile)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	switch command {
+	case "list":
+		return queueList(st, args)
+	case "requeue":
+		return queueRequeue(st, args)
+	default:
+		return fmt.Errorf("unknown queue command %q\n%s", command, usage)
+	}
+}
+
+func queueList(st *store.Store, args []string) error {
+	flags := flag.NewFlagSet("queue list", flag.ExitOnError)
+	statuses := flags.String("status", "failed,dead,review", "comma separated statuses to show, or \"all\"")
+	flags.Parse(args)
+
+	var filter func(*store.Record) bool
+	if *statuses != "all" {
+		var wanted []store.Status
+		for _, status := range strings.Split(*statuses, ",") {
+			wanted = append(wanted, store.Status(strings.TrimSpace(status)))
+		}
+		filter = store.WithStatus(wanted...)
+	}
+
+	records, err := st.List(filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MESSAGE ID\tSTATUS\tATTEMPTS\tNEXT ATTEMPT\tDOCUMENTS\tSUBJECT\tLAST ERROR / REASON")
+	for _, r := range records {
+		next := "-"
+		if r.Status == store.StatusFailed {
+			next = r.NextAttemptAt.Format(time.RFC3339)
+		}
+		detail := r.LastError
+		if detail == "" {
+			detail = r.Reason
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", r.MessageID, r.Status, r.Attempts, next, documentSummary(r.Documents), r.Subject, detail)
+	}
+
+	return w.Flush()
+}
+
+// documentSummary counts the documents of an email per status, such as
+// "2 booked, 1 failed".
+func documentSummary(documents []store.Document) string {
+	if len(documents) == 0 {
+		return "-"
+	}
+
+	var statuses []store.Status
+	counts := make(map[store.Status]int)
+	for _, doc := range documents {
+		if counts[doc.Status] == 0 {
+			statuses = append(statuses, doc.Status)
+		}
+		counts[doc.Status]++
+	}
+
+	var parts []string
+	for _, status := range statuses {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func queueRequeue(st *store.Store, args []string) error {
+	flags := flag.NewFlagSet("queue requeue", flag.ExitOnError)
+	allDead := flags.Bool("all-dead", false, "requeue every dead-lettered email")
+	contactID := flags.String("contact", "", "book on this Moneybird contact ID, for emails flagged for review")
+	approve := flags.Bool("approve", false, "book emails that a vendor rule flagged for review")
+	approveVAT := flags.String("approve-vat", "", "book the document with this reference (or #position, as shown in the reason) at its extracted VAT rates")
+	reextract := flags.Bool("reextract", false, "ask the model again instead of reusing its stored answer")
+	flags.Parse(args)
+
+	ids := flags.Args()
+	if *allDead {
+		dead, err := st.List(store.WithStatus(store.StatusDead))
+		if err != nil {
+			return err
+		}
+		for _, r := range dead {
+			ids = append(ids, r.MessageID)
+		}
+	}
+
+	if len(ids) == 0 {
+		return fmt.Errorf("no message IDs given")
+	}
+
+	for _, id := range ids {
+		record, err := st.Get(id)
+		if err != nil {
+			return fmt.Errorf("requeueing %s: %w", id, err)
+		}
+
+		if *approveVAT != "" {
+			if err := record.ApproveVAT(*approveVAT); err != nil {
+				return fmt.Errorf("requeueing %s: %w", id, err)
+			}
+		}
+		record.Requeue()
+		if *contactID != "" {
+			record.ContactOverride = *contactID
+		}
+		if *approve {
+			record.Approved = true
+		}
+		if *reextract {
+			record.Extraction = nil
+		}
+		if err := st.Put(record); err != nil {
+			return err
+		}
+		fmt.Printf("Requeued %s (%s)\n", id, record.Subject)
+	}
+
+	return nil
+}
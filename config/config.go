@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/spf13/viper"
 	"os"
+	"slices"
 	"time"
 )
 
@@ -13,24 +14,104 @@ type Config struct {
 		ClientSecret string `mapstructure:"client_secret"`
 		RedirectURI  string `mapstructure:"redirect_uri,omitempty"`
 		Token        string `mapstructure:"token"`
+		TokenFile    string `mapstructure:"token_file"`
 		AdminID      string `mapstructure:"admin_id"`
 		Country      string `mapstructure:"country"`
+		// Currency is the currency of the administration.
+		Currency string `mapstructure:"currency"`
+
+		// ContactEnrichment is off, fill (only empty fields) or overwrite.
+		ContactEnrichment string `mapstructure:"contact_enrichment"`
+
+		// Ledger maps invoice items to ledger accounts, referenced by ID,
+		// account code or name. Vendors are keyed by contact ID, VAT number
+		// or company name and win over the suggested category.
+		Ledger struct {
+			Default    string            `mapstructure:"default"`
+			Categories map[string]string `mapstructure:"categories"`
+			Vendors    map[string]string `mapstructure:"vendors"`
+		} `mapstructure:"ledger"`
+
+		// TaxRates finds the tax rates for the special VAT treatments by
+		// name, with case-insensitive regular expressions keyed by
+		// treatment. Other rates are matched by percentage.
+		TaxRates map[string]string `mapstructure:"tax_rates"`
 	} `mapstructure:"moneybird"`
 
 	Gmail struct {
 		CredentialsFile string `mapstructure:"credentials_file"`
-		Token           string `mapstructure:"token"`
-		 SearchLabel     string `mapstructure:"search_label"`
+		TokenFile       string `mapstructure:"token_file"`
+		SearchLabel     string `mapstructure:"search_label"`
+		MaxPerCycle     int    `mapstructure:"max_per_cycle"`
+
+		// Labels records the processing outcome on each message. Enabling it
+		// requests the gmail.modify scope, so Gmail has to be authorized again.
+		Labels struct {
+			Enabled            bool   `mapstructure:"enabled"`
+			Booked             string `mapstructure:"booked"`
+			Skipped            string `mapstructure:"skipped"`
+			Failed             string `mapstructure:"failed"`
+			Review             string `mapstructure:"review"`
+			RemoveTriggerLabel bool   `mapstructure:"remove_trigger_label"`
+			Archive            bool   `mapstructure:"archive"`
+		} `mapstructure:"labels"`
 	} `mapstructure:"gmail"`
 
+	// Filter decides which emails are worth sending to the model. Senders
+	// are full addresses or domains; sizes are in bytes, 0 means no limit.
+	Filter struct {
+		Keywords          []string `mapstructure:"keywords"`
+		SenderAllow       []string `mapstructure:"sender_allow"`
+		SenderDeny        []string `mapstructure:"sender_deny"`
+		RequirePDF        bool     `mapstructure:"require_pdf"`
+		MaxEmailSize      int64    `mapstructure:"max_email_size"`
+		MaxAttachmentSize int64    `mapstructure:"max_attachment_size"`
+	} `mapstructure:"filter"`
+
+	// LLM selects the model that extracts invoices. Provider is openai,
+	// azure, anthropic or ollama; ollama covers any local OpenAI-compatible
+	// server through base_url. Vision sends photos and scanned PDFs as
+	// images and should be disabled for text-only models.
+	LLM struct {
+		Provider   string `mapstructure:"provider"`
+		Model      string `mapstructure:"model"`
+		BaseURL    string `mapstructure:"base_url"`
+		APIKey     string `mapstructure:"api_key"`
+		APIVersion string `mapstructure:"api_version"`
+		Vision     bool   `mapstructure:"vision"`
+	} `mapstructure:"llm"`
+
+	// FX converts foreign currency totals for the ledger. Provider is none,
+	// static (a YAML file of rates per euro) or ecb (the ECB reference rates
+	// XML, from url or the ECB itself).
+	FX struct {
+		Provider string `mapstructure:"provider"`
+		File     string `mapstructure:"file"`
+		URL      string `mapstructure:"url"`
+	} `mapstructure:"fx"`
+
+	// OCR recognizes the text of photos and scanned PDFs with Tesseract
+	// before they reach the model.
+	OCR struct {
+		Enabled   bool   `mapstructure:"enabled"`
+		Languages string `mapstructure:"languages"`
+	} `mapstructure:"ocr"`
+
+	// OpenAI is kept for configurations written before llm existed; its
+	// api_key is used when llm.api_key is empty.
 	OpenAI struct {
 		APIKey string `mapstructure:"api_key"`
 	} `mapstructure:"openai"`
 
 	App struct {
-		LastUpdate  string        `mapstructure:"last_update"`
-		SleepTime   time.Duration `mapstructure:"sleep_time"`
-		TriggerWord string        `mapstructure:"trigger_word"`
+		LastUpdate    string        `mapstructure:"last_update"`
+		StateFile     string        `mapstructure:"state_file"`
+		RulesFile     string        `mapstructure:"rules_file"`
+		SleepTime     time.Duration `mapstructure:"sleep_time"`
+		TriggerWord   string        `mapstructure:"trigger_word"`
+		MaxAttempts   int           `mapstructure:"max_attempts"`
+		RetryDelay    time.Duration `mapstructure:"retry_delay"`
+		MaxRetryDelay time.Duration `mapstructure:"max_retry_delay"`
 	} `mapstructure:"app"`
 }
 
@@ -39,13 +120,29 @@ func (c *Config) Validate() error {
 		condition bool
 		message   string
 	}{
-		{c.Moneybird.ClientID == "", "moneybird client_id is required"},
-		{c.Moneybird.ClientSecret == "", "moneybird client_secret is required"},
+		{c.Moneybird.Token == "" && c.Moneybird.ClientID == "", "moneybird client_id is required"},
+		{c.Moneybird.Token == "" && c.Moneybird.ClientSecret == "", "moneybird client_secret is required"},
 		{c.Moneybird.AdminID == "", "moneybird admin_id is required"},
+		{len(c.Moneybird.Country) != 2, "moneybird country must be a two-letter country code"},
 		{c.Gmail.CredentialsFile == "", "gmail credentials_file is required"},
-		{c.OpenAI.APIKey == "", "openai api_key is required"},
+		{!slices.Contains([]string{"openai", "azure", "anthropic", "ollama"}, c.LLM.Provider), "llm provider must be openai, azure, anthropic or ollama"},
+		{c.LLM.Provider != "ollama" && c.LLM.APIKey == "", "llm api_key is required"},
+		{c.LLM.Provider == "azure" && c.LLM.BaseURL == "", "llm base_url is required for azure"},
+		{c.LLM.Provider == "azure" && c.LLM.Model == "", "llm model (the deployment name) is required for azure"},
+		{c.LLM.Provider == "anthropic" && c.LLM.Model == "", "llm model is required for anthropic"},
+		{c.LLM.Provider == "ollama" && c.LLM.Model == "", "llm model is required for ollama"},
 		{c.App.SleepTime < time.Second, "app sleep_time must be at least 1 second"},
 		{c.Gmail.SearchLabel == "", "gmail search_label is required"},
+		{c.App.StateFile == "", "app state_file is required"},
+		{!slices.Contains([]string{"off", "fill", "overwrite"}, c.Moneybird.ContactEnrichment), "moneybird contact_enrichment must be off, fill or overwrite"},
+		{c.App.MaxAttempts < 1, "app max_attempts must be at least 1"},
+		{c.App.RetryDelay <= 0, "app retry_delay must be positive"},
+		{c.App.MaxRetryDelay < c.App.RetryDelay, "app max_retry_delay cannot be less than retry_delay"},
+		{c.Gmail.MaxPerCycle < 0, "gmail max_per_cycle cannot be negative"},
+		{!slices.Contains([]string{"none", "static", "ecb"}, c.FX.Provider), "fx provider must be none, static or ecb"},
+		{c.FX.Provider == "static" && c.FX.File == "", "fx file is required for the static provider"},
+		{c.Filter.MaxEmailSize < 0, "filter max_email_size cannot be negative"},
+		{c.Filter.MaxAttachmentSize < 0, "filter max_attachment_size cannot be negative"},
 	}
 
 	for _, check := range checks {
@@ -61,11 +158,50 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// LoadConfig reads and validates the configuration needed to run the processor.
 func LoadConfig() (*Config, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+// ReadConfig reads the configuration without validating it, for commands
+// such as `birdgpt auth` that run before the configuration is complete.
+func ReadConfig() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 
+	viper.SetDefault("moneybird.token_file", "moneybird_token.json")
+	viper.SetDefault("moneybird.contact_enrichment", "fill")
+	viper.SetDefault("moneybird.currency", "EUR")
+	viper.SetDefault("moneybird.tax_rates.reverse_charge_eu", `binnen (de )?eu|intra|within (the )?eu|icp`)
+	viper.SetDefault("moneybird.tax_rates.import", `buiten (de )?eu|outside (the )?eu|import`)
+	viper.SetDefault("moneybird.tax_rates.reverse_charge_domestic", `verlegd|reverse`)
+	viper.SetDefault("moneybird.tax_rates.exempt", `geen btw|vrijgesteld|exempt|no vat`)
+	viper.SetDefault("moneybird.tax_rates.zero", `^0%|nultarief|zero`)
+	viper.SetDefault("gmail.token_file", "gmail_token.json")
+	viper.SetDefault("gmail.max_per_cycle", 50)
+	viper.SetDefault("gmail.labels.booked", "BirdGPT/Booked")
+	viper.SetDefault("gmail.labels.skipped", "BirdGPT/Skipped")
+	viper.SetDefault("gmail.labels.failed", "BirdGPT/Failed")
+	viper.SetDefault("gmail.labels.review", "BirdGPT/Review")
+	viper.SetDefault("llm.provider", "openai")
+	viper.SetDefault("ocr.languages", "nld+eng")
+	viper.SetDefault("fx.provider", "none")
+	viper.SetDefault("app.state_file", "birdgpt.db")
+	viper.SetDefault("app.rules_file", "rules.yaml")
+	viper.SetDefault("app.max_attempts", 5)
+	viper.SetDefault("app.retry_delay", "5m")
+	viper.SetDefault("app.max_retry_delay", "6h")
+
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("reading config: %w", err)
 	}
@@ -75,26 +211,30 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("unmarshaling config: %w", err)
 	}
 
-	if err := config.Validate(); err != nil {
-		return nil, fmt.Errorf("invalid config: %w", err)
+	if config.LLM.APIKey == "" && (config.LLM.Provider == "openai" || config.LLM.Provider == "azure") {
+		config.LLM.APIKey = config.OpenAI.APIKey
+	}
+
+	// Most local models are text-only and answer images with an error.
+	if !viper.IsSet("llm.vision") {
+		config.LLM.Vision = config.LLM.Provider != "ollama"
 	}
 
 	return &config, nil
 }
 
 func SaveConfig(config *Config) error {
-    viper.Set("moneybird.token", config.Moneybird.Token)
-    viper.Set("gmail.token", config.Gmail.Token)
-    viper.Set("app.last_update", config.App.LastUpdate)
-    
-    if err := viper.WriteConfig(); err != nil {
-        return fmt.Errorf("writing config: %w", err)
-    }
-    
-    // Force viper to read the config file again
-    if err := viper.ReadInConfig(); err != nil {
-        return fmt.Errorf("reloading config: %w", err)
-    }
-    
-    return nil
-}
\ No newline at end of file
+	viper.Set("moneybird.token", config.Moneybird.Token)
+	viper.Set("moneybird.admin_id", config.Moneybird.AdminID)
+
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("writing config: %w", err)
+	}
+
+	// Force viper to read the config file again
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("reloading config: %w", err)
+	}
+
+	return nil
+}
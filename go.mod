@@ -5,6 +5,7 @@ go 1.23.2
 require (
 	github.com/sashabaranov/go-openai v1.35.6
 	github.com/spf13/viper v1.19.0
+	go.etcd.io/bbolt v1.3.11
 	golang.org/x/oauth2 v0.24.0
 	google.golang.org/api v0.206.0
 )
@@ -45,10 +46,10 @@ require (
 	golang.org/x/exp v0.0.0-20230905200255-921286631fa9 // indirect
 	golang.org/x/net v0.31.0 // indirect
 	golang.org/x/sys v0.27.0 // indirect
-	golang.org/x/text v0.20.0 // indirect
+	golang.org/x/text v0.20.0
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20241104194629-dd2ea8efbc28 // indirect
 	google.golang.org/grpc v1.67.1 // indirect
 	google.golang.org/protobuf v1.35.1 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,205 @@
+// Package anthropic extracts invoices with the Anthropic Messages API. The
+// invoice schema is offered as the only tool, so the model has to answer
+// with JSON matching it.
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+)
+
+const (
+	defaultBaseURL = "https://api.anthropic.com/v1"
+	apiVersion     = "2023-06-01"
+	toolName       = "record_invoice"
+	maxTokens      = 4096
+)
+
+type Options struct {
+	APIKey string
+	// Model is required: Anthropic retires models, so there is no default.
+	Model   string
+	BaseURL string
+	// Vision sends images and scanned PDFs to the model as images.
+	Vision bool
+	// OCR recognizes the text of images and scanned PDFs before they are
+	// sent as images.
+	OCR extraction.OCR
+}
+
+type Client struct {
+	httpClient *http.Client
+	apiKey     string
+	model      string
+	baseURL    string
+	content    extraction.ContentOptions
+}
+
+func NewClient(opts Options) *Client {
+	client := &Client{
+		httpClient: &http.Client{Timeout: 2 * time.Minute},
+		apiKey:     opts.APIKey,
+		model:      opts.Model,
+		baseURL:    strings.TrimSuffix(opts.BaseURL, "/"),
+		content:    extraction.ContentOptions{Vision: opts.Vision, OCR: opts.OCR},
+	}
+	if client.baseURL == "" {
+		client.baseURL = defaultBaseURL
+	}
+	return client
+}
+
+// APIError is returned when the Anthropic API answers with an error status.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("anthropic API error %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether retrying the request later may succeed: rate
+// limits, overload and server errors.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type message struct {
+	Role    string         `json:"role"`
+	Content []contentBlock `json:"content"`
+}
+
+type contentBlock struct {
+	Type   string       `json:"type"`
+	Text   string       `json:"text,omitempty"`
+	Source *imageSource `json:"source,omitempty"`
+}
+
+type imageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type request struct {
+	Model      string            `json:"model"`
+	MaxTokens  int               `json:"max_tokens"`
+	System     string            `json:"system"`
+	Messages   []message         `json:"messages"`
+	Tools      []tool            `json:"tools"`
+	ToolChoice map[string]string `json:"tool_choice"`
+}
+
+type response struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+func (c *Client) Extract(ctx context.Context, input extraction.Input) ([]*extraction.InvoiceData, error) {
+	schema, err := extraction.Schema()
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := extraction.Content(ctx, input, c.content)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.createMessage(ctx, request{
+		Model:     c.model,
+		MaxTokens: maxTokens,
+		System:    extraction.SystemPrompt,
+		Messages:  []message{{Role: "user", Content: contentBlocks(parts)}},
+		Tools: []tool{{
+			Name:        toolName,
+			Description: "Record the invoice data extracted from the email.",
+			InputSchema: schema,
+		}},
+		ToolChoice: map[string]string{"type": "tool", "name": toolName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Anthropic request failed: %w", err)
+	}
+
+	for _, block := range resp.Content {
+		if block.Type == "tool_use" && block.Name == toolName {
+			return extraction.Decode(block.Input)
+		}
+	}
+
+	return nil, fmt.Errorf("Anthropic response has no %s tool call (stop reason %s)", toolName, resp.StopReason)
+}
+
+func contentBlocks(parts []extraction.Part) []contentBlock {
+	var blocks []contentBlock
+	for _, part := range parts {
+		if part.Image == nil {
+			blocks = append(blocks, contentBlock{Type: "text", Text: part.Text})
+			continue
+		}
+
+		blocks = append(blocks, contentBlock{
+			Type: "image",
+			Source: &imageSource{
+				Type:      "base64",
+				MediaType: part.Image.MimeType,
+				Data:      base64.StdEncoding.EncodeToString(part.Image.Data),
+			},
+		})
+	}
+	return blocks
+}
+
+func (c *Client) createMessage(ctx context.Context, body request) (*response, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/messages", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("anthropic-version", apiVersion)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+
+	var decoded response
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+
+	return &decoded, nil
+}
@@ -0,0 +1,197 @@
+package extraction
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxImageSize keeps images within the limits of every provider.
+	maxImageSize = 5 << 20
+	// maxPDFPages caps the pages of a scanned PDF sent as images.
+	maxPDFPages = 5
+)
+
+// Part is a piece of the user message: either text or an image.
+type Part struct {
+	Text  string
+	Image *Image
+}
+
+type Image struct {
+	MimeType string
+	Data     []byte
+}
+
+// ContentOptions decide how attachments without a text layer are sent.
+type ContentOptions struct {
+	// Vision sends photos and scanned PDFs as images.
+	Vision bool
+	// OCR, when set, recognizes their text first; the images are only sent
+	// when it finds none.
+	OCR OCR
+}
+
+// Content renders the email and its attachments as message parts. Each
+// attachment is sent as text when it has any: PDFs with a text layer and
+// text files. Photos and scanned PDFs are recognized with OCR, sent as
+// images when vision is enabled, or left out otherwise.
+func Content(ctx context.Context, input Input, opts ContentOptions) ([]Part, error) {
+	b := contentBuilder{opts: opts}
+	b.text("Email content:\n" + input.Body + "\n\n")
+
+	for i, attachment := range input.Attachments {
+		name := fmt.Sprintf("Attachment %d", i+1)
+		if attachment.Filename != "" {
+			name += fmt.Sprintf(" (%s)", attachment.Filename)
+		}
+
+		if err := b.attachment(ctx, name, attachment); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return b.parts, nil
+}
+
+type contentBuilder struct {
+	opts  ContentOptions
+	parts []Part
+}
+
+// text appends to the previous text part, so text and images alternate.
+func (b *contentBuilder) text(s string) {
+	if n := len(b.parts); n > 0 && b.parts[n-1].Image == nil {
+		b.parts[n-1].Text += s
+		return
+	}
+	b.parts = append(b.parts, Part{Text: s})
+}
+
+func (b *contentBuilder) image(name string, img Image) {
+	if len(img.Data) > maxImageSize {
+		b.text(fmt.Sprintf("%s: image omitted, too large\n\n", name))
+		return
+	}
+	b.text(name + ":\n")
+	b.parts = append(b.parts, Part{Image: &img})
+}
+
+// recognize adds the OCR text of the attachment and reports whether there
+// was any. OCR failures fall back to vision.
+func (b *contentBuilder) recognize(ctx context.Context, name string, attachment Attachment) bool {
+	if b.opts.OCR == nil {
+		return false
+	}
+
+	text, err := b.opts.OCR.Recognize(ctx, attachment)
+	if err != nil {
+		log.Printf("OCR of %s failed: %v", name, err)
+		return false
+	}
+	if strings.TrimSpace(text) == "" {
+		return false
+	}
+
+	b.text(fmt.Sprintf("%s content (OCR):\n%s\n\n", name, text))
+	return true
+}
+
+func (b *contentBuilder) attachment(ctx context.Context, name string, attachment Attachment) error {
+	data, vision := attachment.Data, b.opts.Vision
+	switch {
+	case isPDF(data):
+		text, err := extractTextFromPDF(data)
+		if err != nil {
+			return fmt.Errorf("failed to extract text from PDF: %w", err)
+		}
+		if strings.TrimSpace(text) != "" {
+			b.text(fmt.Sprintf("%s content (PDF):\n%s\n\n", name, text))
+			return nil
+		}
+		if b.recognize(ctx, name, attachment) {
+			return nil
+		}
+		if !vision {
+			b.text(fmt.Sprintf("%s: scanned PDF without text, omitted\n\n", name))
+			return nil
+		}
+
+		pages, err := rasterizePDF(ctx, data, maxPDFPages)
+		if err != nil {
+			return err
+		}
+		for i, page := range pages {
+			b.image(fmt.Sprintf("%s page %d (scanned PDF)", name, i+1), Image{MimeType: "image/png", Data: page})
+		}
+		return nil
+
+	case isHEIC(attachment):
+		if b.recognize(ctx, name, attachment) {
+			return nil
+		}
+		if !vision {
+			b.text(fmt.Sprintf("%s: image omitted\n\n", name))
+			return nil
+		}
+		converted, err := convertHEIC(ctx, data)
+		if err != nil {
+			return err
+		}
+		b.image(name, Image{MimeType: "image/jpeg", Data: converted})
+		return nil
+
+	case isImage(data):
+		if b.recognize(ctx, name, attachment) {
+			return nil
+		}
+		if !vision {
+			b.text(fmt.Sprintf("%s: image omitted\n\n", name))
+			return nil
+		}
+		b.image(name, Image{MimeType: http.DetectContentType(data), Data: data})
+		return nil
+
+	case strings.HasPrefix(attachment.MimeType, "text/") && utf8.Valid(data):
+		b.text(fmt.Sprintf("%s content:\n%s\n\n", name, data))
+		return nil
+
+	default:
+		log.Printf("Leaving out %s of type %s", name, attachment.MimeType)
+		b.text(fmt.Sprintf("%s: unsupported file type %s, omitted\n\n", name, attachment.MimeType))
+		return nil
+	}
+}
+
+// isImage reports whether the data is an image format all providers accept.
+func isImage(data []byte) bool {
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	default:
+		return false
+	}
+}
+
+func isHEIC(attachment Attachment) bool {
+	switch attachment.MimeType {
+	case "image/heic", "image/heif":
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(attachment.Filename)) {
+	case ".heic", ".heif":
+		return true
+	}
+	// ISO media files carry their brand after the ftyp box header.
+	data := attachment.Data
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	brand := string(data[8:12])
+	return strings.HasPrefix(brand, "hei") || strings.HasPrefix(brand, "hev") || brand == "mif1"
+}
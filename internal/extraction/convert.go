@@ -0,0 +1,88 @@
+package extraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// rasterizePDF renders the first pages of a PDF as PNG images with pdftoppm
+// from poppler-utils.
+func rasterizePDF(ctx context.Context, data []byte, maxPages int) ([][]byte, error) {
+	dir, err := os.MkdirTemp("", "birdgpt-pdf-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.pdf")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-png", "-r", "150", "-l", fmt.Sprint(maxPages), input, filepath.Join(dir, "page"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("rasterizing PDF with pdftoppm: %w: %s", err, out)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "page*.png"))
+	if err != nil {
+		return nil, err
+	}
+	// pdftoppm pads page numbers, so names sort in page order.
+	sort.Strings(files)
+
+	var pages [][]byte
+	for _, file := range files {
+		page, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("rasterizing PDF: no pages rendered")
+	}
+	return pages, nil
+}
+
+// heicConverters are tried in order; each takes an input and output path.
+var heicConverters = []string{"heif-dec", "heif-convert", "magick"}
+
+// convertHEIC converts a HEIC photo, as sent by iPhones, to JPEG with
+// libheif's command line tools or ImageMagick, whichever is installed.
+func convertHEIC(ctx context.Context, data []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "birdgpt-heic-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "input.heic")
+	output := filepath.Join(dir, "output.jpg")
+	if err := os.WriteFile(input, data, 0600); err != nil {
+		return nil, err
+	}
+
+	for _, converter := range heicConverters {
+		path, err := exec.LookPath(converter)
+		if errors.Is(err, exec.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if out, err := exec.CommandContext(ctx, path, input, output).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("converting HEIC with %s: %w: %s", converter, err, out)
+		}
+		return os.ReadFile(output)
+	}
+
+	return nil, fmt.Errorf("converting HEIC: install libheif or ImageMagick")
+}
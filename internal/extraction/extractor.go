@@ -0,0 +1,70 @@
+// Package extraction turns emails into structured invoice data. The model
+// behind it is pluggable: providers live in subpackages and implement
+// Extractor with the shared prompt and schema from this package.
+package extraction
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Extractor extracts the invoices in an email. An email without invoices
+// yields none.
+type Extractor interface {
+	Extract(ctx context.Context, input Input) ([]*InvoiceData, error)
+}
+
+// Input is the content of an email as seen by the model.
+type Input struct {
+	Body        string
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename string
+	MimeType string
+	Data     []byte
+}
+
+// SystemPrompt instructs the model how to read the email.
+var SystemPrompt = `You are an invoice processing assistant. First determine if the content contains any invoices.
+An email can contain several invoices, for example one PDF attachment per invoice. Return one document per invoice
+with the number of the attachment it is in, or 0 when it is in the email body. An invoice in the body that is repeated
+in an attachment is a single document. Return no documents when there is no invoice.
+For each invoice, extract the relevant information. Pay special attention to KVK (Chamber of Commerce) and BTW (VAT) numbers,
+which are often found in the header or footer of Dutch invoices. BTW numbers typically start with NL and KVK numbers
+are 8 digits. Parse the address into separate components.
+
+Consider invoice indicators like: payment terms, invoice numbers, line items, tax amounts.
+Credit notes and refunds count as invoices too: set the document type to credit_note, give their amounts as negative
+numbers and include the number of the invoice they credit when it is mentioned. Set the document type to receipt for
+till receipts and payment receipts, and to invoice otherwise.
+For Dutch companies, always try to find the KVK and BTW numbers.
+Always try to parse the full address into separate components.
+Use ISO country codes for the country field.
+Give the currency of the amounts as an ISO 4217 code, based on the currency symbol or code on the invoice.
+For each item, give the quantity, the unit price and any discount on the line as an amount, and the line total after
+discount. Tell whether the item amounts include VAT (gross) or exclude it (net), and give the total VAT of the invoice.
+Give the tax rate of every item as printed, 0 when no VAT is charged, and tell whether the item is for goods or services.
+Copy any remark about VAT, such as "BTW verlegd", "reverse charge" or an exemption, into the tax note.
+If the invoice mentions the IBAN the supplier wants to be paid on, include it.
+For each item, choose the category that describes the expense best from: ` + strings.Join(Categories, ", ") + `.`
+
+// Decode parses the model's answer into the invoices it found.
+func Decode(content []byte) ([]*InvoiceData, error) {
+	var extraction Extraction
+	if err := json.Unmarshal(content, &extraction); err != nil {
+		return nil, fmt.Errorf("parsing model response: %w", err)
+	}
+
+	var invoices []*InvoiceData
+	for i := range extraction.Documents {
+		if extraction.Documents[i].IsInvoice {
+			invoices = append(invoices, &extraction.Documents[i])
+		}
+	}
+
+	return invoices, nil
+}
@@ -0,0 +1,71 @@
+package extraction
+
+import "github.com/janyksteenbeek/birdgpt/internal/money"
+
+// Extraction is the model's answer: every invoice found in the email.
+type Extraction struct {
+	Documents []InvoiceData `json:"documents" description:"One entry per invoice in the email, empty when there is none"`
+}
+
+// Document types. Credit notes carry negative amounts.
+const (
+	DocumentInvoice    = "invoice"
+	DocumentCreditNote = "credit_note"
+	DocumentReceipt    = "receipt"
+)
+
+type InvoiceData struct {
+	IsInvoice    bool   `json:"is_invoice"`
+	DocumentType string `json:"document_type" enum:"invoice,credit_note,receipt" description:"invoice, credit_note for credit notes and refunds, or receipt for till and payment receipts"`
+	// SourceAttachment is the 1-based number of the attachment holding the
+	// invoice, 0 for the email body.
+	SourceAttachment int    `json:"source_attachment" description:"Number of the attachment the invoice is in, 0 when it is in the email body"`
+	CompanyName      string `json:"company_name,omitempty"`
+	InvoiceNumber    string `json:"invoice_number,omitempty"`
+	// OriginalReference is the number of the invoice a credit note corrects.
+	OriginalReference string        `json:"original_reference,omitempty" description:"For credit notes, the number of the invoice being credited"`
+	InvoiceDate       string        `json:"invoice_date,omitempty"`
+	DueDate           string        `json:"due_date,omitempty"`
+	Items             []InvoiceItem `json:"items,omitempty"`
+	PricesInclTax     bool          `json:"prices_incl_tax" description:"Whether the item amounts and unit prices include VAT"`
+	Currency          string        `json:"currency,omitempty" description:"ISO 4217 currency code of the amounts, such as EUR or USD"`
+	TotalAmount       money.Amount  `json:"total_amount,omitempty"`
+	TaxAmount         money.Amount  `json:"tax_amount,omitempty"`
+	TaxNote           string        `json:"tax_note,omitempty" description:"Remarks on the invoice about VAT, such as BTW verlegd, reverse charge or an exemption"`
+	ContactInfo       ContactInfo   `json:"contact_info,omitempty"`
+	KvkNumber         string        `json:"kvk_number,omitempty"`
+	VatNumber         string        `json:"vat_number,omitempty"`
+	IBAN              string        `json:"iban,omitempty"`
+}
+
+// InvoiceItem is a line of the invoice. Amount is the line total: Quantity
+// times UnitPrice, less Discount. UnitPrice keeps every decimal, as usage
+// and fuel prices such as 0.0116 or 1.949 go below the cent.
+type InvoiceItem struct {
+	Description string       `json:"description"`
+	Quantity    float64      `json:"quantity,omitempty" description:"Number of units, 1 when the line shows none"`
+	UnitPrice   float64      `json:"unit_price,omitempty" description:"Price per unit before discount, with all its decimals"`
+	Discount    money.Amount `json:"discount,omitempty" description:"Discount on the line as an amount"`
+	Amount      money.Amount `json:"amount" description:"Line total after discount"`
+	TaxRate     float64      `json:"tax_rate"`
+	LineType    string       `json:"line_type,omitempty" enum:"goods,services" description:"Whether the line is for goods or services"`
+	Category    string       `json:"category"`
+}
+
+// Categories the model picks from for each invoice item. They are mapped to
+// ledger accounts through the moneybird.ledger configuration.
+var Categories = []string{
+	"software", "hosting", "telecom", "hardware", "office_supplies", "rent",
+	"utilities", "travel", "fuel", "meals", "marketing", "advertising",
+	"professional_services", "insurance", "training", "shipping", "bank_fees",
+	"other",
+}
+
+type ContactInfo struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Street  string `json:"street"`
+	City    string `json:"city"`
+	ZipCode string `json:"zipcode"`
+	Country string `json:"country"`
+}
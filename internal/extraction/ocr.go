@@ -0,0 +1,124 @@
+package extraction
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// maxOCRPages caps the pages of a scanned PDF that are recognized.
+const maxOCRPages = 10
+
+// OCR recognizes the text of attachments without a text layer: scanned PDFs
+// and photos.
+type OCR interface {
+	Recognize(ctx context.Context, attachment Attachment) (string, error)
+}
+
+// Cache keeps recognized text, so an attachment is only recognized once even
+// when its email is retried.
+type Cache interface {
+	CachedText(key string) (string, bool, error)
+	CacheText(key, text string) error
+}
+
+// Tesseract runs the tesseract command line tool.
+type Tesseract struct {
+	// Languages as passed to tesseract -l, such as nld+eng.
+	Languages string
+}
+
+func NewTesseract(languages string) *Tesseract {
+	return &Tesseract{Languages: languages}
+}
+
+func (t *Tesseract) Recognize(ctx context.Context, attachment Attachment) (string, error) {
+	var images [][]byte
+	switch {
+	case isPDF(attachment.Data):
+		pages, err := rasterizePDF(ctx, attachment.Data, maxOCRPages)
+		if err != nil {
+			return "", err
+		}
+		images = pages
+	case isHEIC(attachment):
+		converted, err := convertHEIC(ctx, attachment.Data)
+		if err != nil {
+			return "", err
+		}
+		images = [][]byte{converted}
+	case isImage(attachment.Data):
+		images = [][]byte{attachment.Data}
+	default:
+		return "", fmt.Errorf("cannot recognize text in %s", attachment.MimeType)
+	}
+
+	var text strings.Builder
+	for _, image := range images {
+		page, err := t.recognizeImage(ctx, image)
+		if err != nil {
+			return "", err
+		}
+		text.WriteString(page)
+		text.WriteString("\n")
+	}
+
+	return text.String(), nil
+}
+
+func (t *Tesseract) recognizeImage(ctx context.Context, image []byte) (string, error) {
+	args := []string{"stdin", "stdout"}
+	if t.Languages != "" {
+		args = append(args, "-l", t.Languages)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "tesseract", args...)
+	cmd.Stdin = bytes.NewReader(image)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running tesseract: %w: %s", err, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+// CachedOCR wraps an OCR with a cache keyed by the SHA-256 hash of the
+// attachment. The variant, such as the Tesseract languages, is part of the
+// key, so changing it recognizes attachments again.
+type CachedOCR struct {
+	ocr     OCR
+	cache   Cache
+	variant string
+}
+
+func NewCachedOCR(ocr OCR, cache Cache, variant string) *CachedOCR {
+	return &CachedOCR{ocr: ocr, cache: cache, variant: variant}
+}
+
+func (c *CachedOCR) Recognize(ctx context.Context, attachment Attachment) (string, error) {
+	sum := sha256.Sum256(attachment.Data)
+	key := c.variant + ":" + hex.EncodeToString(sum[:])
+
+	if text, ok, err := c.cache.CachedText(key); err != nil {
+		log.Printf("Failed to read OCR cache: %v", err)
+	} else if ok {
+		return text, nil
+	}
+
+	text, err := c.ocr.Recognize(ctx, attachment)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.cache.CacheText(key, text); err != nil {
+		log.Printf("Failed to write OCR cache: %v", err)
+	}
+	return text, nil
+}
@@ -0,0 +1,134 @@
+// Package openai extracts invoices with the OpenAI chat completions API. It
+// also talks to Azure OpenAI and to local OpenAI-compatible servers such as
+// Ollama and llama.cpp.
+package openai
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// DefaultModel is used when no model is configured.
+	DefaultModel = openai.GPT4o
+	// DefaultAzureAPIVersion is the first Azure API version with structured
+	// outputs.
+	DefaultAzureAPIVersion = "2024-08-01-preview"
+)
+
+type Options struct {
+	APIKey string
+	Model  string
+	// BaseURL points at another OpenAI-compatible server, such as
+	// http://localhost:11434/v1 for Ollama. For Azure it is the resource
+	// endpoint.
+	BaseURL string
+	// Azure switches to Azure OpenAI, where Model is the deployment name.
+	Azure      bool
+	APIVersion string
+	// Vision sends images and scanned PDFs to the model as images.
+	Vision bool
+	// OCR recognizes the text of images and scanned PDFs before they are
+	// sent as images.
+	OCR extraction.OCR
+}
+
+type Client struct {
+	client  *openai.Client
+	model   string
+	content extraction.ContentOptions
+}
+
+func NewClient(opts Options) *Client {
+	config := openai.DefaultConfig(opts.APIKey)
+	if opts.Azure {
+		config = openai.DefaultAzureConfig(opts.APIKey, opts.BaseURL)
+		config.APIVersion = DefaultAzureAPIVersion
+		if opts.APIVersion != "" {
+			config.APIVersion = opts.APIVersion
+		}
+		// Deployment names are used as-is.
+		config.AzureModelMapperFunc = func(model string) string { return model }
+	} else if opts.BaseURL != "" {
+		config.BaseURL = opts.BaseURL
+	}
+
+	model := opts.Model
+	if model == "" {
+		model = DefaultModel
+	}
+
+	return &Client{
+		client:  openai.NewClientWithConfig(config),
+		model:   model,
+		content: extraction.ContentOptions{Vision: opts.Vision, OCR: opts.OCR},
+	}
+}
+
+func (c *Client) Extract(ctx context.Context, input extraction.Input) ([]*extraction.InvoiceData, error) {
+	schema, err := extraction.Schema()
+	if err != nil {
+		return nil, err
+	}
+
+	parts, err := extraction.Content(ctx, input, c.content)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.CreateChatCompletion(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model: c.model,
+			Messages: []openai.ChatCompletionMessage{
+				{
+					Role:    openai.ChatMessageRoleSystem,
+					Content: extraction.SystemPrompt,
+				},
+				{
+					Role:         openai.ChatMessageRoleUser,
+					MultiContent: messageParts(parts),
+				},
+			},
+			ResponseFormat: &openai.ChatCompletionResponseFormat{
+				Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+				JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+					Name:   "invoice_schema",
+					Schema: schema,
+				},
+			},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI request failed: %w", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("OpenAI returned no choices")
+	}
+
+	return extraction.Decode([]byte(resp.Choices[0].Message.Content))
+}
+
+func messageParts(parts []extraction.Part) []openai.ChatMessagePart {
+	var message []openai.ChatMessagePart
+	for _, part := range parts {
+		if part.Image == nil {
+			message = append(message, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: part.Text})
+			continue
+		}
+
+		message = append(message, openai.ChatMessagePart{
+			Type: openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{
+				URL:    "data:" + part.Image.MimeType + ";base64," + base64.StdEncoding.EncodeToString(part.Image.Data),
+				Detail: openai.ImageURLDetailHigh,
+			},
+		})
+	}
+	return message
+}
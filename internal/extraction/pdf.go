@@ -0,0 +1,37 @@
+package extraction
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func isPDF(data []byte) bool {
+	return len(data) > 4 && string(data[:4]) == "%PDF"
+}
+
+func extractTextFromPDF(data []byte) (string, error) {
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to create PDF reader: %w", err)
+	}
+
+	var text string
+	numPages := reader.NumPage()
+
+	for pageNum := 1; pageNum <= numPages; pageNum++ {
+		page := reader.Page(pageNum)
+		if page.V.IsNull() {
+			continue
+		}
+
+		extractedText, err := page.GetPlainText(nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to extract text from page %d: %w", pageNum, err)
+		}
+		text += extractedText
+	}
+
+	return text, nil
+}
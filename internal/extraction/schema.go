@@ -0,0 +1,58 @@
+package extraction
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/janyksteenbeek/birdgpt/internal/money"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+var amountType = reflect.TypeOf(money.Amount(0))
+
+// Schema returns the JSON schema the model's answer has to follow.
+func Schema() (*jsonschema.Definition, error) {
+	schema, err := jsonschema.GenerateSchemaForType(Extraction{})
+	if err != nil {
+		return nil, fmt.Errorf("generating invoice schema: %w", err)
+	}
+	decorate(schema, reflect.TypeOf(Extraction{}))
+	return schema, nil
+}
+
+// decorate fixes what the generator derives from Go kinds alone: amounts are
+// stored as integer cents but the model answers with decimal numbers, and
+// fields with an enum tag only take the listed values.
+func decorate(def *jsonschema.Definition, t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		decorate(def, t.Elem())
+	case reflect.Slice, reflect.Array:
+		if def.Items != nil {
+			decorate(def.Items, t.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.TrimSuffix(field.Tag.Get("json"), ",omitempty")
+			if name == "" {
+				name = field.Name
+			}
+
+			prop, ok := def.Properties[name]
+			if !ok {
+				continue
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				prop.Enum = strings.Split(enum, ",")
+			}
+			if field.Type == amountType {
+				prop.Type = jsonschema.Number
+			} else {
+				decorate(&prop, field.Type)
+			}
+			def.Properties[name] = prop
+		}
+	}
+}
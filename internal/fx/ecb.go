@@ -0,0 +1,108 @@
+package fx
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ECBHistoryURL publishes the reference rates of the last 90 days, so the
+// rate of the invoice date can be used.
+const ECBHistoryURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+
+// refreshInterval matches the ECB's daily publication.
+const refreshInterval = 12 * time.Hour
+
+// ECB reads the euro reference rates XML of the European Central Bank, from
+// the ECB itself or a copy served locally.
+type ECB struct {
+	url        string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	history   history
+	fetchedAt time.Time
+}
+
+func NewECB(url string) *ECB {
+	if url == "" {
+		url = ECBHistoryURL
+	}
+	return &ECB{url: url, httpClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string  `xml:"currency,attr"`
+			Rate     float64 `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+func (e *ECB) Rate(ctx context.Context, from, to string, date time.Time) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.history == nil || time.Since(e.fetchedAt) > refreshInterval {
+		h, err := e.fetch(ctx)
+		if err != nil {
+			return 0, err
+		}
+		e.history, e.fetchedAt = h, time.Now()
+	}
+
+	r, err := e.history.on(date)
+	if err != nil {
+		return 0, err
+	}
+	return r.cross(from, to)
+}
+
+func (e *ECB) fetch(ctx context.Context) (history, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", e.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching exchange rates: status %d", resp.StatusCode)
+	}
+
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("parsing exchange rates: %w", err)
+	}
+
+	h := make(history)
+	for _, day := range envelope.Days {
+		date, err := time.Parse("2006-01-02", day.Time)
+		if err != nil {
+			continue
+		}
+		r := make(rates, len(day.Rates))
+		for _, rate := range day.Rates {
+			r[rate.Currency] = rate.Rate
+		}
+		h[date] = r
+	}
+
+	if len(h) == 0 {
+		return nil, fmt.Errorf("parsing exchange rates: no rates found")
+	}
+	return h, nil
+}
@@ -0,0 +1,87 @@
+// Package fx provides exchange rates for invoices in a foreign currency.
+// Rates are quoted per euro, as published by the ECB, and crossed for other
+// pairs.
+package fx
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/janyksteenbeek/birdgpt/internal/money"
+)
+
+// Provider returns how many units of to one unit of from is worth on date.
+type Provider interface {
+	Rate(ctx context.Context, from, to string, date time.Time) (float64, error)
+}
+
+// Convert converts an amount with the provider's rate for date.
+func Convert(ctx context.Context, provider Provider, amount money.Money, to string, date time.Time) (money.Money, float64, error) {
+	rate, err := provider.Rate(ctx, amount.Currency, to, date)
+	if err != nil {
+		return money.Money{}, 0, err
+	}
+	return money.Money{Amount: money.FromFloat(amount.Amount.Float64() * rate), Currency: to}, rate, nil
+}
+
+// rates holds the euro rates of a single day.
+type rates map[string]float64
+
+// cross returns the rate from one currency to another through the euro.
+func (r rates) cross(from, to string) (float64, error) {
+	perEuro := func(currency string) (float64, error) {
+		currency = strings.ToUpper(currency)
+		if currency == "EUR" {
+			return 1, nil
+		}
+		rate, ok := r[currency]
+		if !ok || rate <= 0 {
+			return 0, fmt.Errorf("no exchange rate for %s", currency)
+		}
+		return rate, nil
+	}
+
+	fromRate, err := perEuro(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := perEuro(to)
+	if err != nil {
+		return 0, err
+	}
+	return toRate / fromRate, nil
+}
+
+// history holds rates by day.
+type history map[time.Time]rates
+
+// on returns the rates of the latest day up to date, which covers weekends
+// and holidays. Dates before the first day are an error: a later rate would
+// be booked as if it applied on the invoice date.
+func (h history) on(date time.Time) (rates, error) {
+	if len(h) == 0 {
+		return nil, fmt.Errorf("no exchange rates loaded")
+	}
+
+	days := make([]time.Time, 0, len(h))
+	for day := range h {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	if date.Before(days[0]) {
+		return nil, fmt.Errorf("no exchange rates for %s, the rates start on %s", date.Format("2006-01-02"), days[0].Format("2006-01-02"))
+	}
+
+	chosen := days[0]
+	for _, day := range days {
+		if day.After(date) {
+			break
+		}
+		chosen = day
+	}
+	return h[chosen], nil
+}
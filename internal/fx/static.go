@@ -0,0 +1,39 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Static serves fixed rates from a YAML file mapping currency codes to their
+// value per euro, such as "USD: 1.0832".
+type Static struct {
+	rates rates
+}
+
+func LoadStatic(path string) (*Static, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading exchange rates: %w", err)
+	}
+
+	var parsed map[string]float64
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("parsing exchange rates: %w", err)
+	}
+
+	r := make(rates, len(parsed))
+	for currency, rate := range parsed {
+		r[strings.ToUpper(currency)] = rate
+	}
+	return &Static{rates: r}, nil
+}
+
+func (s *Static) Rate(ctx context.Context, from, to string, date time.Time) (float64, error) {
+	return s.rates.cross(from, to)
+}
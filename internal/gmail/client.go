@@ -2,11 +2,16 @@ package gmail
 
 import (
     "context"
-    "encoding/base64"
+    "errors"
     "fmt"
+    "log"
+    "net/mail"
     "os"
+    "slices"
+    "strings"
     "time"
 
+    "github.com/janyksteenbeek/birdgpt/internal/oauth"
     "golang.org/x/oauth2"
     "golang.org/x/oauth2/google"
     "google.golang.org/api/gmail/v1"
@@ -14,87 +19,122 @@ import (
 )
 
 type Client struct {
-    service *gmail.Service
+    service  *gmail.Service
+    labelIDs map[string]string
 }
 
-type Email struct {
-    ID          string
-    From        string
-    Subject     string
-    Body        string
-    Date        time.Time
-    Attachments [][]byte
-}
-
-func Setup(ctx context.Context, credentialsFile string, token string) (*Client, string, error) {
+// oauthConfig builds the OAuth config from the credentials file. The modify
+// scope is only requested when BirdGPT needs to label or archive messages.
+func oauthConfig(credentialsFile string, modify bool) (*oauth2.Config, error) {
     creds, err := os.ReadFile(credentialsFile)
     if err != nil {
-        return nil, "", fmt.Errorf("reading credentials: %w", err)
+        return nil, fmt.Errorf("reading credentials: %w", err)
+    }
+
+    scope := gmail.GmailReadonlyScope
+    if modify {
+        scope = gmail.GmailModifyScope
     }
 
-    config, err := google.ConfigFromJSON(creds, gmail.GmailReadonlyScope)
+    config, err := google.ConfigFromJSON(creds, scope)
     if err != nil {
-        return nil, "", fmt.Errorf("parsing credentials: %w", err)
+        return nil, fmt.Errorf("parsing credentials: %w", err)
     }
 
-    if token == "" {
-        return nil, config.AuthCodeURL("state"), nil
+    return config, nil
+}
+
+// ErrNotAuthorized is returned by Setup when no Gmail token has been stored yet.
+var ErrNotAuthorized = errors.New("gmail is not authorized, run `birdgpt auth gmail` first")
+
+func Setup(ctx context.Context, credentialsFile string, tokenFile string, modify bool) (*Client, error) {
+    config, err := oauthConfig(credentialsFile, modify)
+    if err != nil {
+        return nil, err
     }
 
-    oauthClient := config.Client(ctx, &oauth2.Token{AccessToken: token})
+    store := oauth.NewFileStore(tokenFile)
+    token, err := store.Load()
+    if errors.Is(err, oauth.ErrNoToken) {
+        return nil, ErrNotAuthorized
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    oauthClient := oauth2.NewClient(ctx, oauth.TokenSource(ctx, config, store, token))
     srv, err := gmail.NewService(ctx, option.WithHTTPClient(oauthClient))
     if err != nil {
-        return nil, "", fmt.Errorf("creating gmail service: %w", err)
+        return nil, fmt.Errorf("creating gmail service: %w", err)
     }
 
-    return &Client{service: srv}, "", nil
+    return &Client{service: srv, labelIDs: make(map[string]string)}, nil
 }
 
-func Exchange(ctx context.Context, credentialsFile string, code string) (string, error) {
-    creds, err := os.ReadFile(credentialsFile)
+// Authorize runs the interactive OAuth flow and stores the resulting token in tokenFile.
+func Authorize(ctx context.Context, credentialsFile string, tokenFile string, modify bool, opts oauth.AuthorizeOptions) error {
+    config, err := oauthConfig(credentialsFile, modify)
     if err != nil {
-        return "", fmt.Errorf("reading credentials: %w", err)
+        return err
     }
 
-    config, err := google.ConfigFromJSON(creds, gmail.GmailReadonlyScope)
-    if err != nil {
-        return "", fmt.Errorf("parsing credentials: %w", err)
+    if opts.RedirectURL == "" {
+        // Desktop OAuth clients accept any port on the loopback address.
+        opts.RedirectURL = "http://127.0.0.1:0/"
     }
+    // Offline access with forced consent makes Google hand out a refresh token.
+    opts.AuthCodeOptions = append(opts.AuthCodeOptions, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
 
-    token, err := config.Exchange(ctx, code)
+    token, err := oauth.Authorize(ctx, config, opts)
     if err != nil {
-        return "", fmt.Errorf("exchanging code: %w", err)
+        return err
+    }
+
+    if token.RefreshToken == "" {
+        log.Println("Warning: Google did not return a refresh token, Gmail access will expire in about an hour")
     }
 
-    return token.AccessToken, nil
+    if err := oauth.NewFileStore(tokenFile).Save(token); err != nil {
+        return fmt.Errorf("saving token: %w", err)
+    }
+
+    return nil
 }
 
-func (c *Client) FetchEmails(ctx context.Context, label string, after time.Time) ([]Email, error) {
-    query := fmt.Sprintf("label:%s after:%d", label, after.Unix())
-    msgs, err := c.service.Users.Messages.List("me").Q(query).Do()
+// ListMessageIDs returns the IDs of all messages matching query, following
+// every result page. IDs are returned oldest first.
+func (c *Client) ListMessageIDs(ctx context.Context, query string) ([]string, error) {
+    var ids []string
+    err := c.service.Users.Messages.List("me").Q(query).MaxResults(500).Pages(ctx, func(page *gmail.ListMessagesResponse) error {
+        for _, msg := range page.Messages {
+            ids = append(ids, msg.Id)
+        }
+        return nil
+    })
     if err != nil {
         return nil, fmt.Errorf("listing messages: %w", err)
     }
 
-    var emails []Email
-    for _, msg := range msgs.Messages {
-        email, err := c.fetchEmail(msg.Id)
-        if err != nil {
-            return nil, fmt.Errorf("fetching email %s: %w", msg.Id, err)
-        }
-        emails = append(emails, *email)
-    }
+    // Gmail lists newest first.
+    slices.Reverse(ids)
+    return ids, nil
+}
 
-    return emails, nil
+func (c *Client) FetchEmail(ctx context.Context, messageID string) (*Email, error) {
+    email, err := c.fetchEmail(ctx, messageID)
+    if err != nil {
+        return nil, fmt.Errorf("fetching email %s: %w", messageID, err)
+    }
+    return email, nil
 }
 
-func (c *Client) fetchEmail(messageID string) (*Email, error) {
-    msg, err := c.service.Users.Messages.Get("me", messageID).Do()
+func (c *Client) fetchEmail(ctx context.Context, messageID string) (*Email, error) {
+    msg, err := c.service.Users.Messages.Get("me", messageID).Context(ctx).Do()
     if err != nil {
         return nil, err
     }
 
-    email := &Email{ID: messageID}
+    email := &Email{ID: messageID, ReceivedAt: time.UnixMilli(msg.InternalDate)}
     for _, header := range msg.Payload.Headers {
         switch header.Name {
         case "From":
@@ -102,43 +142,69 @@ func (c *Client) fetchEmail(messageID string) (*Email, error) {
         case "Subject":
             email.Subject = header.Value
         case "Date":
-            if t, err := time.Parse(time.RFC1123Z, header.Value); err == nil {
+            if t, err := mail.ParseDate(header.Value); err == nil {
                 email.Date = t
             }
         }
     }
 
-    email.Body, email.Attachments = c.extractContent(messageID, msg.Payload)
+    if err := c.walkParts(ctx, messageID, msg.Payload, email); err != nil {
+        return nil, err
+    }
     return email, nil
 }
 
-func (c *Client) extractContent(messageID string, part *gmail.MessagePart) (string, [][]byte) {
-    var body string
-    var attachments [][]byte
-
-    if part.Body != nil && part.Body.Data != "" {
-        if data, err := base64.URLEncoding.DecodeString(part.Body.Data); err == nil {
-            body = string(data)
+// walkParts descends the whole MIME tree, collecting every text/plain and
+// text/html body and every attachment or inline file.
+func (c *Client) walkParts(ctx context.Context, messageID string, part *gmail.MessagePart, email *Email) error {
+    if len(part.Parts) > 0 {
+        for _, p := range part.Parts {
+            if err := c.walkParts(ctx, messageID, p, email); err != nil {
+                return err
+            }
         }
+        return nil
     }
 
-    for _, p := range part.Parts {
-        if p.Body != nil && p.Body.Data != "" {
-            if data, err := base64.URLEncoding.DecodeString(p.Body.Data); err == nil {
-                body = string(data)
-            }
-            continue
-        }
+    if part.Body == nil || (part.Body.Data == "" && part.Body.AttachmentId == "") {
+        return nil
+    }
 
-        if p.Body != nil && p.Body.AttachmentId != "" {
-            att, err := c.service.Users.Messages.Attachments.Get("me", messageID, p.Body.AttachmentId).Do()
-            if err == nil {
-                if data, err := base64.URLEncoding.DecodeString(att.Data); err == nil {
-                    attachments = append(attachments, data)
-                }
-            }
+    disposition, contentID := partHeaders(part)
+    mimeType := strings.ToLower(part.MimeType)
+    isBody := part.Filename == "" && disposition != "attachment" &&
+        (mimeType == "text/plain" || mimeType == "text/html")
+
+    data, err := c.partData(ctx, messageID, part)
+    if err != nil {
+        return fmt.Errorf("reading part %s: %w", part.PartId, err)
+    }
+
+    if isBody {
+        email.appendBody(mimeType, string(data))
+        return nil
+    }
+
+    email.Attachments = append(email.Attachments, Attachment{
+        Filename:  part.Filename,
+        MimeType:  mimeType,
+        Size:      int64(len(data)),
+        ContentID: contentID,
+        Inline:    disposition == "inline" || (disposition == "" && contentID != ""),
+        Data:      data,
+    })
+    return nil
+}
+
+func (c *Client) partData(ctx context.Context, messageID string, part *gmail.MessagePart) ([]byte, error) {
+    encoded := part.Body.Data
+    if part.Body.AttachmentId != "" {
+        att, err := c.service.Users.Messages.Attachments.Get("me", messageID, part.Body.AttachmentId).Context(ctx).Do()
+        if err != nil {
+            return nil, err
         }
+        encoded = att.Data
     }
 
-    return body, attachments
-} 
\ No newline at end of file
+    return decodeBase64URL(encoded)
+}
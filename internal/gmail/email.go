@@ -0,0 +1,108 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"html"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+type Email struct {
+	ID          string
+	From        string
+	Subject     string
+	TextBody    string
+	HTMLBody    string
+	Date        time.Time
+	ReceivedAt  time.Time
+	Attachments []Attachment
+}
+
+// Attachment is a non-body MIME part. Inline parts (such as logos referenced
+// from the HTML body) are included with Inline set.
+type Attachment struct {
+	Filename  string
+	MimeType  string
+	Size      int64
+	ContentID string
+	Inline    bool
+	Data      []byte
+}
+
+// Body returns the plain text body, falling back to the HTML body with its
+// markup stripped.
+func (e *Email) Body() string {
+	if strings.TrimSpace(e.TextBody) != "" {
+		return e.TextBody
+	}
+	return HTMLToText(e.HTMLBody)
+}
+
+// Files returns the attachments that are not inline parts of the body.
+func (e *Email) Files() []Attachment {
+	var files []Attachment
+	for _, att := range e.Attachments {
+		if !att.Inline {
+			files = append(files, att)
+		}
+	}
+	return files
+}
+
+func (e *Email) appendBody(mimeType, content string) {
+	target := &e.TextBody
+	if mimeType == "text/html" {
+		target = &e.HTMLBody
+	}
+
+	if *target != "" {
+		*target += "\n"
+	}
+	*target += content
+}
+
+func partHeaders(part *gmail.MessagePart) (disposition, contentID string) {
+	for _, header := range part.Headers {
+		switch strings.ToLower(header.Name) {
+		case "content-disposition":
+			if d, _, err := mime.ParseMediaType(header.Value); err == nil {
+				disposition = d
+			} else {
+				disposition = strings.ToLower(strings.TrimSpace(strings.SplitN(header.Value, ";", 2)[0]))
+			}
+		case "content-id":
+			contentID = strings.Trim(strings.TrimSpace(header.Value), "<>")
+		}
+	}
+	return disposition, contentID
+}
+
+func decodeBase64URL(data string) ([]byte, error) {
+	if decoded, err := base64.URLEncoding.DecodeString(data); err == nil {
+		return decoded, nil
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+}
+
+var (
+	htmlDropRegex  = regexp.MustCompile(`(?is)<(script|style|head)[^>]*>.*?</(script|style|head)>`)
+	htmlBreakRegex = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/li|/h[1-6])[^>]*>`)
+	htmlCellRegex  = regexp.MustCompile(`(?i)</t[dh]>`)
+	htmlTagRegex   = regexp.MustCompile(`<[^>]+>`)
+	blankLineRegex = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+)
+
+// HTMLToText turns an HTML body into readable plain text.
+func HTMLToText(body string) string {
+	text := htmlDropRegex.ReplaceAllString(body, "")
+	text = htmlBreakRegex.ReplaceAllString(text, "\n")
+	text = htmlCellRegex.ReplaceAllString(text, "\t")
+	text = htmlTagRegex.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = blankLineRegex.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
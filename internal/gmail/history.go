@@ -0,0 +1,74 @@
+package gmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// ErrHistoryExpired is returned by LabelHistory when Gmail no longer has the
+// history for the start ID, which requires a full resync.
+var ErrHistoryExpired = errors.New("gmail history id has expired")
+
+// HistoryID returns the current history ID of the mailbox.
+func (c *Client) HistoryID(ctx context.Context) (uint64, error) {
+	profile, err := c.service.Users.GetProfile("me").Context(ctx).Do()
+	if err != nil {
+		return 0, fmt.Errorf("getting profile: %w", err)
+	}
+
+	return profile.HistoryId, nil
+}
+
+// LabelHistory returns the IDs of messages that were added with, or later
+// received, the label since startHistoryID, oldest first, along with the
+// history ID to continue from.
+func (c *Client) LabelHistory(ctx context.Context, startHistoryID uint64, labelID string) ([]string, uint64, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	add := func(msg *gmail.Message, labels []string) {
+		if msg == nil || seen[msg.Id] || !slices.Contains(labels, labelID) {
+			return
+		}
+		seen[msg.Id] = true
+		ids = append(ids, msg.Id)
+	}
+
+	latest := startHistoryID
+	err := c.service.Users.History.List("me").
+		StartHistoryId(startHistoryID).
+		LabelId(labelID).
+		HistoryTypes("labelAdded", "messageAdded").
+		MaxResults(500).
+		Pages(ctx, func(page *gmail.ListHistoryResponse) error {
+			for _, h := range page.History {
+				for _, added := range h.MessagesAdded {
+					if added.Message != nil {
+						add(added.Message, added.Message.LabelIds)
+					}
+				}
+				for _, added := range h.LabelsAdded {
+					add(added.Message, added.LabelIds)
+				}
+			}
+			if page.HistoryId > latest {
+				latest = page.HistoryId
+			}
+			return nil
+		})
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return nil, 0, ErrHistoryExpired
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("listing history: %w", err)
+	}
+
+	return ids, latest, nil
+}
@@ -0,0 +1,59 @@
+package gmail
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// LabelID resolves a label name to its ID. Missing labels are created when
+// create is set. Results are cached for the lifetime of the client.
+func (c *Client) LabelID(ctx context.Context, name string, create bool) (string, error) {
+	if id, ok := c.labelIDs[name]; ok {
+		return id, nil
+	}
+
+	labels, err := c.service.Users.Labels.List("me").Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("listing labels: %w", err)
+	}
+
+	for _, label := range labels.Labels {
+		c.labelIDs[label.Name] = label.Id
+	}
+
+	if id, ok := c.labelIDs[name]; ok {
+		return id, nil
+	}
+
+	if !create {
+		return "", fmt.Errorf("label %q does not exist", name)
+	}
+
+	label, err := c.service.Users.Labels.Create("me", &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("creating label %q: %w", name, err)
+	}
+
+	c.labelIDs[label.Name] = label.Id
+	return label.Id, nil
+}
+
+// ModifyLabels adds and removes label IDs on a message. Removing the INBOX
+// label archives it. This requires the modify scope.
+func (c *Client) ModifyLabels(ctx context.Context, messageID string, add, remove []string) error {
+	_, err := c.service.Users.Messages.Modify("me", messageID, &gmail.ModifyMessageRequest{
+		AddLabelIds:    add,
+		RemoveLabelIds: remove,
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("modifying labels of %s: %w", messageID, err)
+	}
+
+	return nil
+}
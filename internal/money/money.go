@@ -0,0 +1,165 @@
+// Package money holds amounts as integer cents, so totals add up exactly and
+// are sent to Moneybird as the decimal strings it expects.
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is assumed when an amount comes without one.
+const DefaultCurrency = "EUR"
+
+// Amount is a monetary amount in cents. It reads JSON numbers and strings
+// and writes decimal strings such as "12.50".
+type Amount int64
+
+// Money is an amount in a currency.
+type Money struct {
+	Amount   Amount
+	Currency string
+}
+
+func (m Money) String() string {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return currency + " " + m.Amount.String()
+}
+
+// FromFloat rounds a float to whole cents, half away from zero.
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * 100))
+}
+
+// Parse reads a decimal amount such as "12.50", "-3", "1.234,56" or
+// "1,234.56". The last dot or comma is taken as the decimal separator when
+// it is followed by at most two digits; other separators are dropped.
+// Further decimals are rounded to whole cents, half away from zero.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty amount")
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative, s = true, s[1:]
+	case '+':
+		s = s[1:]
+	}
+	if !strings.ContainsAny(s, "0123456789") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	whole, fraction := s, ""
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 {
+		// "1.234" is a thousands separator, unless it is the only one and a
+		// dot, as in plain decimals like "0.125".
+		digits := len(s) - i - 1
+		if digits != 3 || (s[i] == '.' && strings.Count(s, ".")+strings.Count(s, ",") == 1) {
+			whole, fraction = s[:i], s[i+1:]
+		}
+	}
+	whole = strings.NewReplacer(".", "", ",", "", " ", "", "_", "").Replace(whole)
+	if whole == "" {
+		whole = "0"
+	}
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	cents := units * 100
+	padded := fraction + "00"
+	c, _ := strconv.ParseInt(padded[:2], 10, 64)
+	cents += c
+	if len(fraction) > 2 && fraction[2] >= '5' {
+		cents++
+	}
+
+	if negative {
+		cents = -cents
+	}
+	return Amount(cents), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the amount with two decimals and a dot, as Moneybird does.
+func (a Amount) String() string {
+	sign := ""
+	cents := int64(a)
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+func (a Amount) Float64() float64 {
+	return float64(a) / 100
+}
+
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Within reports whether a and b differ by at most tolerance.
+func Within(a, b, tolerance Amount) bool {
+	return (a - b).Abs() <= tolerance
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*a = 0
+		return nil
+	}
+
+	s := string(data)
+	if data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		if strings.TrimSpace(s) == "" {
+			*a = 0
+			return nil
+		}
+	} else if strings.ContainsAny(s, "eE") {
+		// Exponents only come from floats, which are rounded to cents.
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", s)
+		}
+		*a = FromFloat(f)
+		return nil
+	}
+
+	amount, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
@@ -0,0 +1,117 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{"12.50", 1250},
+		{"-3", -300},
+		{"+3", 300},
+		{" 7 ", 700},
+		{"0", 0},
+		{".5", 50},
+		{"12.", 1200},
+		// Decimal separators
+		{"1,5", 150},
+		{"1234,56", 123456},
+		{"1.234,56", 123456},
+		{"1,234.56", 123456},
+		{"1 234,56", 123456},
+		{"1_234.56", 123456},
+		// A single dot with three digits is a decimal, other separators
+		// followed by three digits group thousands.
+		{"0.125", 13},
+		{"1.234", 123},
+		{"1,234", 123400},
+		{"1.234.567", 123456700},
+		{"1,234,567", 123456700},
+		// Rounding to cents, half away from zero
+		{"0.124", 12},
+		{"0.129", 13},
+		{"0.995", 100},
+		{"-0.125", -13},
+		{"-0.124", -12},
+		{"12,3456", 1235},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{"", " ", "abc", "12a", "1.2x", "--1", "+-1", "€12", "-", "+", ".", ",", "-.", " , "} {
+		if got, err := Parse(in); err == nil {
+			t.Errorf("Parse(%q) = %d, want error", in, got)
+		}
+	}
+}
+
+func TestAmountUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Amount
+	}{
+		{`12.5`, 1250},
+		{`-12.5`, -1250},
+		{`"12.50"`, 1250},
+		{`"1.234,56"`, 123456},
+		{`"-0,99"`, -99},
+		{`1.005`, 101},
+		{`1e2`, 10000},
+		{`1.2345E1`, 1235},
+		{`null`, 0},
+		{`""`, 0},
+		{`" "`, 0},
+	}
+
+	for _, tt := range tests {
+		var got Amount
+		if err := json.Unmarshal([]byte(tt.in), &got); err != nil {
+			t.Errorf("unmarshaling %s returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("unmarshaling %s = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`"abc"`, `true`, `"12a"`} {
+		var got Amount
+		if err := json.Unmarshal([]byte(in), &got); err == nil {
+			t.Errorf("unmarshaling %s = %d, want error", in, got)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		in   Amount
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1250, "12.50"},
+		{-5, "-0.05"},
+		{-123456, "-1234.56"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
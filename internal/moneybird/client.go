@@ -8,12 +8,16 @@ import (
 	"time"
 )
 
+const apiURL = "https://moneybird.com/api/v2"
+
 type Client struct {
 	httpClient *http.Client
 	baseURL    string
-	token      string
 	adminID    string
-	taxRates   map[float64]string
+	taxRates   []TaxRate
+
+	ledgerAccounts   []LedgerAccount
+	ledgerAccountsAt time.Time
 }
 
 type TaxRate struct {
@@ -24,13 +28,13 @@ type TaxRate struct {
 	Active      bool    `json:"active"`
 }
 
-func NewClient(token, adminID string) (*Client, error) {
+// NewClient creates a client for a single administration. httpClient must
+// authenticate its requests, see NewHTTPClient.
+func NewClient(httpClient *http.Client, adminID string) (*Client, error) {
 	c := &Client{
-		httpClient: &http.Client{Timeout: time.Second * 30},
-		baseURL:    "https://moneybird.com/api/v2",
-		token:      token,
+		httpClient: httpClient,
+		baseURL:    apiURL,
 		adminID:    adminID,
-		taxRates:   make(map[float64]string),
 	}
 
 	if err := c.initializeTaxRates(); err != nil {
@@ -48,7 +52,7 @@ func (c *Client) initializeTaxRates() error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return newAPIError(resp)
 	}
 
 	var rates []TaxRate
@@ -56,28 +60,14 @@ func (c *Client) initializeTaxRates() error {
 		return err
 	}
 
-	for _, rate := range rates {
-		c.taxRates[rate.Percentage] = rate.ID
-	}
-
+	c.taxRates = rates
 	return nil
 }
 
-func (c *Client) GetTaxRateID(percentage float64) string {
-	if id, ok := c.taxRates[percentage]; ok {
-		return id
-	}
-
-	var closest float64
-	var closestID string
-	for rate, id := range c.taxRates {
-		if closestID == "" || abs(percentage-rate) < abs(percentage-closest) {
-			closest = rate
-			closestID = id
-		}
-	}
-
-	return closestID
+// TaxRates returns the active purchase invoice tax rates of the
+// administration.
+func (c *Client) TaxRates() []TaxRate {
+	return c.taxRates
 }
 
 func (c *Client) doRequest(method, path string, body interface{}) (*http.Response, error) {
@@ -94,20 +84,7 @@ func (c *Client) doRequest(method, path string, body interface{}) (*http.Respons
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.token)
 	req.Header.Set("Content-Type", "application/json")
 
 	return c.httpClient.Do(req)
 }
-
-func abs(x float64) float64 {
-	if x < 0 {
-		return -x
-	}
-	return x
-}
-
-func (c *Client) GetVATShiftedTaxRateID() string {
-	// Look for tax rate with 0% for intracommunity transactions
-	return c.GetTaxRateID(0)
-}
@@ -14,10 +14,11 @@ type Contact struct {
 	Email       string `json:"email"`
 	CustomerId  string `json:"customer_id"`
 	TaxNumber   string `json:"tax_number"`
-	Address     string `json:"address"`
+	Address     string `json:"address1"`
 	City        string `json:"city"`
 	Country     string `json:"country"`
 	ZipCode     string `json:"zipcode"`
+	SepaIban    string `json:"sepa_iban,omitempty"`
 }
 
 func (c *Client) SearchContacts(query string) ([]Contact, error) {
@@ -29,7 +30,7 @@ func (c *Client) SearchContacts(query string) ([]Contact, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.Body)
+		return nil, newAPIError(resp)
 	}
 
 	var contacts []Contact
@@ -40,6 +41,25 @@ func (c *Client) SearchContacts(query string) ([]Contact, error) {
 	return contacts, nil
 }
 
+func (c *Client) GetContact(id string) (*Contact, error) {
+	resp, err := c.doRequest("GET", fmt.Sprintf("contacts/%s.json", url.PathEscape(id)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp)
+	}
+
+	var contact Contact
+	if err := json.NewDecoder(resp.Body).Decode(&contact); err != nil {
+		return nil, err
+	}
+
+	return &contact, nil
+}
+
 func (c *Client) CreateContact(contact *Contact) (*Contact, error) {
 	resp, err := c.doRequest("POST", "contacts.json", map[string]interface{}{
 		"contact": contact,
@@ -50,7 +70,7 @@ func (c *Client) CreateContact(contact *Contact) (*Contact, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, newAPIError(resp)
 	}
 
 	var created Contact
@@ -61,6 +81,28 @@ func (c *Client) CreateContact(contact *Contact) (*Contact, error) {
 	return &created, nil
 }
 
+// UpdateContact patches the given contact attributes, keyed by their API name.
+func (c *Client) UpdateContact(id string, fields map[string]string) (*Contact, error) {
+	resp, err := c.doRequest("PATCH", fmt.Sprintf("contacts/%s.json", url.PathEscape(id)), map[string]interface{}{
+		"contact": fields,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp)
+	}
+
+	var updated Contact
+	if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
 func IsEUCountry(countryCode string) bool {
 	euCountries := map[string]bool{
 		"AT": true, "BE": true, "BG": true, "HR": true, "CY": true,
@@ -3,22 +3,34 @@ package moneybird
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"net/url"
+
+	"github.com/janyksteenbeek/birdgpt/internal/money"
 )
 
 type PurchaseInvoice struct {
-	ID        string          `json:"id"`
-	ContactID string          `json:"contact_id"`
-	Reference string          `json:"reference"`
-	Date      string          `json:"date"`
-	DueDate   string          `json:"due_date"`
-	Details   []InvoiceDetail `json:"details_attributes"`
+	ID               string          `json:"id"`
+	ContactID        string          `json:"contact_id"`
+	Reference        string          `json:"reference"`
+	Date             string          `json:"date"`
+	DueDate          string          `json:"due_date"`
+	Currency         string          `json:"currency,omitempty"`
+	PricesAreInclTax bool            `json:"prices_are_incl_tax"`
+	Details          []InvoiceDetail `json:"details_attributes"`
+
+	// TotalPriceInclTax is set by Moneybird and only present in responses.
+	TotalPriceInclTax money.Amount `json:"total_price_incl_tax,omitempty"`
 }
 
+// InvoiceDetail is a line of a purchase invoice. Amount is the quantity,
+// which Moneybird takes as a string such as "2".
 type InvoiceDetail struct {
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	TaxRateID   string  `json:"tax_rate_id"`
+	Description     string       `json:"description"`
+	Amount          string       `json:"amount,omitempty"`
+	Price           money.Amount `json:"price"`
+	TaxRateID       string       `json:"tax_rate_id"`
+	LedgerAccountID string       `json:"ledger_account_id,omitempty"`
+	ProjectID       string       `json:"project_id,omitempty"`
 }
 
 func (c *Client) CreatePurchaseInvoice(invoice *PurchaseInvoice) (*PurchaseInvoice, error) {
@@ -31,10 +43,7 @@ func (c *Client) CreatePurchaseInvoice(invoice *PurchaseInvoice) (*PurchaseInvoi
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 201 {
-		// get body content
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Println(string(body))
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, newAPIError(resp)
 	}
 
 	var created PurchaseInvoice
@@ -44,3 +53,49 @@ func (c *Client) CreatePurchaseInvoice(invoice *PurchaseInvoice) (*PurchaseInvoi
 
 	return &created, nil
 }
+
+// ListPurchaseInvoices returns the purchase invoices of a contact.
+func (c *Client) ListPurchaseInvoices(contactID string) ([]PurchaseInvoice, error) {
+	var invoices []PurchaseInvoice
+	for page := 1; ; page++ {
+		filter := url.QueryEscape("contact_id:" + contactID)
+		resp, err := c.doRequest("GET", fmt.Sprintf("documents/purchase_invoices.json?filter=%s&per_page=100&page=%d", filter, page), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != 200 {
+			defer resp.Body.Close()
+			return nil, newAPIError(resp)
+		}
+
+		var batch []PurchaseInvoice
+		err = json.NewDecoder(resp.Body).Decode(&batch)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		invoices = append(invoices, batch...)
+		if len(batch) < 100 {
+			return invoices, nil
+		}
+	}
+}
+
+// AddPurchaseInvoiceNote adds a note to the timeline of a purchase invoice.
+func (c *Client) AddPurchaseInvoiceNote(invoiceID, note string) error {
+	resp, err := c.doRequest("POST", fmt.Sprintf("documents/purchase_invoices/%s/notes.json", invoiceID), map[string]interface{}{
+		"note": map[string]string{"note": note},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 201 {
+		return newAPIError(resp)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package moneybird
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ledgerAccountsTTL bounds how long the ledger accounts are cached, so
+// accounts added in Moneybird are picked up without a restart.
+const ledgerAccountsTTL = time.Hour
+
+type LedgerAccount struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	AccountType string `json:"account_type"`
+	// AccountID is the account code shown in Moneybird, such as 4000.
+	AccountID string `json:"account_id"`
+}
+
+// LedgerAccounts returns the ledger accounts of the administration.
+func (c *Client) LedgerAccounts() ([]LedgerAccount, error) {
+	if c.ledgerAccounts != nil && time.Since(c.ledgerAccountsAt) < ledgerAccountsTTL {
+		return c.ledgerAccounts, nil
+	}
+
+	resp, err := c.doRequest("GET", "ledger_accounts.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp)
+	}
+
+	var accounts []LedgerAccount
+	if err := json.NewDecoder(resp.Body).Decode(&accounts); err != nil {
+		return nil, err
+	}
+
+	c.ledgerAccounts = accounts
+	c.ledgerAccountsAt = time.Now()
+	return accounts, nil
+}
+
+// FindLedgerAccount resolves a ledger account by ID, account code or name.
+func (c *Client) FindLedgerAccount(ref string) (*LedgerAccount, error) {
+	accounts, err := c.LedgerAccounts()
+	if err != nil {
+		return nil, fmt.Errorf("fetching ledger accounts: %w", err)
+	}
+
+	ref = strings.TrimSpace(ref)
+	for i, account := range accounts {
+		if account.ID == ref || (account.AccountID != "" && account.AccountID == ref) || strings.EqualFold(account.Name, ref) {
+			return &accounts[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("ledger account %q not found", ref)
+}
@@ -0,0 +1,105 @@
+package moneybird
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/janyksteenbeek/birdgpt/internal/oauth"
+	"golang.org/x/oauth2"
+)
+
+// ErrNotAuthorized is returned by NewHTTPClient when neither a personal token
+// nor a stored OAuth token is available.
+var ErrNotAuthorized = errors.New("moneybird is not authorized, run `birdgpt auth moneybird` first")
+
+var Endpoint = oauth2.Endpoint{
+	AuthURL:  "https://moneybird.com/oauth/authorize",
+	TokenURL: "https://moneybird.com/oauth/token",
+}
+
+// Scopes needed to manage contacts and purchase invoices and to read tax rates.
+var Scopes = []string{"sales_invoices", "documents", "settings"}
+
+type Administration struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Currency string `json:"currency"`
+	Country  string `json:"country"`
+}
+
+func OAuthConfig(clientID, clientSecret, redirectURI string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURI,
+		Endpoint:     Endpoint,
+		Scopes:       Scopes,
+	}
+}
+
+// NewHTTPClient returns an authenticated HTTP client. A personal token is used
+// as-is when set; otherwise the OAuth token in tokenFile is refreshed through
+// config and every rotated token is written back to tokenFile.
+func NewHTTPClient(ctx context.Context, config *oauth2.Config, personalToken, tokenFile string) (*http.Client, error) {
+	var source oauth2.TokenSource
+	if personalToken != "" {
+		source = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: personalToken, TokenType: "Bearer"})
+	} else {
+		store := oauth.NewFileStore(tokenFile)
+		token, err := store.Load()
+		if errors.Is(err, oauth.ErrNoToken) {
+			return nil, ErrNotAuthorized
+		}
+		if err != nil {
+			return nil, err
+		}
+		source = oauth.TokenSource(ctx, config, store, token)
+	}
+
+	client := oauth2.NewClient(ctx, source)
+	client.Timeout = time.Second * 30
+	return client, nil
+}
+
+// Authorize runs the interactive OAuth flow and stores the resulting token in tokenFile.
+func Authorize(ctx context.Context, config *oauth2.Config, tokenFile string, opts oauth.AuthorizeOptions) error {
+	if opts.RedirectURL == "" {
+		opts.RedirectURL = config.RedirectURL
+	}
+
+	token, err := oauth.Authorize(ctx, config, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := oauth.NewFileStore(tokenFile).Save(token); err != nil {
+		return fmt.Errorf("saving token: %w", err)
+	}
+
+	return nil
+}
+
+// ListAdministrations returns the administrations the token behind httpClient can access.
+func ListAdministrations(httpClient *http.Client) ([]Administration, error) {
+	resp, err := httpClient.Get(apiURL + "/administrations.json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newAPIError(resp)
+	}
+
+	var administrations []Administration
+	if err := json.NewDecoder(resp.Body).Decode(&administrations); err != nil {
+		return nil, err
+	}
+
+	return administrations, nil
+}
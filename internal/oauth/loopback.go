@@ -0,0 +1,201 @@
+package oauth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// AuthorizeOptions configures an interactive authorization-code flow.
+type AuthorizeOptions struct {
+	// RedirectURL is the loopback URL the provider redirects to. A port of 0
+	// picks a free port. Headless flows may use any registered redirect URL.
+	RedirectURL string
+	// Headless skips the local listener and asks the user to paste the URL
+	// their browser was redirected to, for use in Docker or over SSH.
+	Headless bool
+	Timeout  time.Duration
+	// AuthCodeOptions are passed to AuthCodeURL, e.g. oauth2.AccessTypeOffline.
+	AuthCodeOptions []oauth2.AuthCodeOption
+	Out             io.Writer
+	In              io.Reader
+}
+
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// Authorize runs the authorization-code flow with a random state and PKCE and
+// returns the exchanged token.
+func Authorize(ctx context.Context, config *oauth2.Config, opts AuthorizeOptions) (*oauth2.Token, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Minute
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	redirect, err := url.Parse(opts.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redirect URL: %w", err)
+	}
+	if !opts.Headless && !isLoopback(redirect.Hostname()) {
+		return nil, fmt.Errorf("redirect URL must point to localhost or a loopback address: %s", opts.RedirectURL)
+	}
+	if redirect.Path == "" {
+		redirect.Path = "/"
+	}
+
+	var listener net.Listener
+	if !opts.Headless || redirect.Port() == "0" {
+		listener, err = net.Listen("tcp", redirect.Host)
+		if err != nil {
+			return nil, fmt.Errorf("listening on %s: %w", redirect.Host, err)
+		}
+		defer listener.Close()
+
+		// Fill in the port that was actually bound when a random one was requested.
+		redirect.Host = net.JoinHostPort(redirect.Hostname(), fmt.Sprint(listener.Addr().(*net.TCPAddr).Port))
+	}
+
+	cfg := *config
+	cfg.RedirectURL = redirect.String()
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authOpts := append([]oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}, opts.AuthCodeOptions...)
+	authURL := cfg.AuthCodeURL(state, authOpts...)
+
+	results := make(chan callbackResult, 1)
+
+	if opts.Headless {
+		if listener != nil {
+			listener.Close()
+		}
+		fmt.Fprintf(opts.Out, "Open the following URL on any device with a browser:\n\n  %s\n\n", authURL)
+		fmt.Fprintln(opts.Out, "After approving, your browser is redirected to a page that will not load.")
+		fmt.Fprint(opts.Out, "Copy the full address from the address bar and paste it here: ")
+		go readPastedRedirect(opts.In, state, results)
+	} else {
+		server := &http.Server{Handler: callbackHandler(redirect.Path, state, results)}
+		go server.Serve(listener)
+		defer server.Close()
+
+		fmt.Fprintf(opts.Out, "Open the following URL in your browser to authorize access:\n\n  %s\n\n", authURL)
+		fmt.Fprintf(opts.Out, "Waiting for the authorization callback on %s (timeout %v)...\n", cfg.RedirectURL, opts.Timeout)
+	}
+
+	var result callbackResult
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for authorization: %w", ctx.Err())
+	case result = <-results:
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	token, err := cfg.Exchange(ctx, result.code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+
+	return token, nil
+}
+
+func callbackHandler(path, state string, results chan<- callbackResult) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		// A path of "/" catches every request, such as the browser asking for
+		// /favicon.ico; only a real callback may end the flow.
+		query := r.URL.Query()
+		if r.URL.Path != path || !isCallback(query) {
+			http.NotFound(w, r)
+			return
+		}
+
+		code, err := codeFromQuery(query, state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Authorization complete, you can close this window.")
+		}
+
+		select {
+		case results <- callbackResult{code: code, err: err}:
+		default:
+		}
+	})
+	return mux
+}
+
+// isCallback reports whether the query carries an authorization response.
+func isCallback(query url.Values) bool {
+	return query.Has("state") || query.Has("code") || query.Has("error")
+}
+
+func readPastedRedirect(in io.Reader, state string, results chan<- callbackResult) {
+	scanner := bufio.NewScanner(in)
+	if !scanner.Scan() {
+		results <- callbackResult{err: errors.New("no redirect URL entered")}
+		return
+	}
+
+	pasted, err := url.Parse(strings.TrimSpace(scanner.Text()))
+	if err != nil {
+		results <- callbackResult{err: fmt.Errorf("parsing redirect URL: %w", err)}
+		return
+	}
+
+	code, err := codeFromQuery(pasted.Query(), state)
+	results <- callbackResult{code: code, err: err}
+}
+
+func codeFromQuery(query url.Values, state string) (string, error) {
+	if e := query.Get("error"); e != "" {
+		return "", fmt.Errorf("authorization denied: %s %s", e, query.Get("error_description"))
+	}
+
+	if query.Get("state") != state {
+		return "", errors.New("state mismatch in authorization callback")
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return "", errors.New("authorization callback did not contain a code")
+	}
+
+	return code, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating state: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
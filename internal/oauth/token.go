@@ -0,0 +1,114 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// ErrNoToken is returned by FileStore.Load when no token has been stored yet.
+var ErrNoToken = errors.New("no token stored")
+
+// FileStore persists a full OAuth2 token (access, refresh, expiry and type)
+// as JSON on disk.
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+func (s *FileStore) Path() string {
+	return s.path
+}
+
+func (s *FileStore) Load() (*oauth2.Token, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading token file: %w", err)
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("parsing token file: %w", err)
+	}
+
+	if token.AccessToken == "" && token.RefreshToken == "" {
+		return nil, ErrNoToken
+	}
+
+	return &token, nil
+}
+
+func (s *FileStore) Save(token *oauth2.Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding token: %w", err)
+	}
+
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("creating token directory: %w", err)
+		}
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated token behind.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing token file: %w", err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("replacing token file: %w", err)
+	}
+
+	return nil
+}
+
+// persistingTokenSource writes every rotated token back to the store.
+type persistingTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	store  *FileStore
+	last   *oauth2.Token
+}
+
+// TokenSource returns a token source that refreshes the stored token through
+// config when it expires and saves each new token to store.
+func TokenSource(ctx context.Context, config *oauth2.Config, store *FileStore, token *oauth2.Token) oauth2.TokenSource {
+	return &persistingTokenSource{
+		source: oauth2.ReuseTokenSource(token, config.TokenSource(ctx, token)),
+		store:  store,
+		last:   token,
+	}
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.last == nil || token.AccessToken != s.last.AccessToken || token.RefreshToken != s.last.RefreshToken {
+		if err := s.store.Save(token); err != nil {
+			log.Printf("Failed to persist refreshed token to %s: %v", s.store.Path(), err)
+		}
+		s.last = token
+	}
+
+	return token, nil
+}
@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+)
+
+// Contact enrichment policies.
+const (
+	EnrichOff       = "off"
+	EnrichFill      = "fill"
+	EnrichOverwrite = "overwrite"
+)
+
+type contactField struct {
+	name    string
+	current func(*moneybird.Contact) string
+	value   func(*extraction.InvoiceData) string
+}
+
+var enrichableFields = []contactField{
+	{"email", func(c *moneybird.Contact) string { return c.Email }, func(d *extraction.InvoiceData) string { return d.ContactInfo.Email }},
+	{"customer_id", func(c *moneybird.Contact) string { return c.CustomerId }, func(d *extraction.InvoiceData) string { return d.KvkNumber }},
+	{"tax_number", func(c *moneybird.Contact) string { return c.TaxNumber }, func(d *extraction.InvoiceData) string { return d.VatNumber }},
+	{"address1", func(c *moneybird.Contact) string { return c.Address }, func(d *extraction.InvoiceData) string { return d.ContactInfo.Street }},
+	{"zipcode", func(c *moneybird.Contact) string { return c.ZipCode }, func(d *extraction.InvoiceData) string { return d.ContactInfo.ZipCode }},
+	{"city", func(c *moneybird.Contact) string { return c.City }, func(d *extraction.InvoiceData) string { return d.ContactInfo.City }},
+	{"country", func(c *moneybird.Contact) string { return c.Country }, func(d *extraction.InvoiceData) string { return d.ContactInfo.Country }},
+	{"sepa_iban", func(c *moneybird.Contact) string { return c.SepaIban }, func(d *extraction.InvoiceData) string { return d.IBAN }},
+}
+
+// contactChanges lists the updates the policy allows: fill only touches
+// empty fields, overwrite also replaces values that differ.
+func contactChanges(policy string, contact *moneybird.Contact, data *extraction.InvoiceData) []store.ContactChange {
+	if policy != EnrichFill && policy != EnrichOverwrite {
+		return nil
+	}
+
+	var changes []store.ContactChange
+	for _, field := range enrichableFields {
+		current := strings.TrimSpace(field.current(contact))
+		value := strings.TrimSpace(field.value(data))
+		if value == "" || strings.EqualFold(current, value) {
+			continue
+		}
+		if current != "" && policy != EnrichOverwrite {
+			continue
+		}
+		changes = append(changes, store.ContactChange{Field: field.name, Old: current, New: value})
+	}
+
+	return changes
+}
+
+// enrichContact updates an existing contact with newly extracted details
+// according to policy and returns the updated contact together with the
+// changes made.
+func (p *MoneybirdProcessor) enrichContact(contact *moneybird.Contact, data *extraction.InvoiceData, policy string) (*moneybird.Contact, []store.ContactChange, error) {
+	changes := contactChanges(policy, contact, data)
+	if len(changes) == 0 {
+		return contact, nil, nil
+	}
+
+	fields := make(map[string]string, len(changes))
+	for _, change := range changes {
+		fields[change.Field] = change.New
+		if change.Old == "" {
+			log.Printf("Contact %s: setting %s to %q", contact.CompanyName, change.Field, change.New)
+		} else {
+			log.Printf("Contact %s: changing %s from %q to %q", contact.CompanyName, change.Field, change.Old, change.New)
+		}
+	}
+
+	updated, err := p.moneybird.UpdateContact(contact.ID, fields)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to update contact %s: %w", contact.ID, err)
+	}
+
+	return updated, changes, nil
+}
@@ -0,0 +1,169 @@
+package processor
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+)
+
+// Score thresholds for contact matches. A confident match is booked, a
+// match between the thresholds is flagged for review and anything lower is
+// treated as an unknown contact.
+const (
+	matchConfident = 0.8
+	matchReview    = 0.4
+)
+
+type contactMatch struct {
+	contact *moneybird.Contact
+	score   float64
+	signals []string
+}
+
+func (m *contactMatch) String() string {
+	return fmt.Sprintf("%s (score %.2f: %s)", m.contact.CompanyName, m.score, strings.Join(m.signals, ", "))
+}
+
+// scoreContact weighs every signal the invoice offers against a contact.
+// Registration numbers are decisive either way, names only count when they
+// are equal after dropping legal suffixes and are never enough on their own
+// for a confident match.
+func scoreContact(contact *moneybird.Contact, data *extraction.InvoiceData, senderDomain string) *contactMatch {
+	m := &contactMatch{contact: contact}
+	add := func(weight float64, signal string) {
+		m.score += weight
+		m.signals = append(m.signals, signal)
+	}
+
+	if vat, other := normalizeReference(data.VatNumber), normalizeReference(contact.TaxNumber); vat != "" && other != "" {
+		if vat == other {
+			add(1.0, "VAT number")
+		} else {
+			add(-1.0, "different VAT number")
+		}
+	}
+
+	if kvk, other := normalizeReference(data.KvkNumber), normalizeReference(contact.CustomerId); kvk != "" && other != "" {
+		if kvk == other {
+			add(1.0, "KVK number")
+		} else {
+			add(-0.5, "different KVK number")
+		}
+	}
+
+	if iban := normalizeReference(data.IBAN); iban != "" && iban == normalizeReference(contact.SepaIban) {
+		add(0.9, "IBAN")
+	}
+
+	if domain := emailDomain(contact.Email); domain != "" && !isFreemail(domain) {
+		if domain == senderDomain || domain == emailDomain(data.ContactInfo.Email) {
+			add(0.4, "email domain")
+		}
+	}
+
+	name, other := normalizeCompanyName(data.CompanyName), normalizeCompanyName(contact.CompanyName)
+	switch {
+	case name == "" || other == "":
+	case name == other:
+		add(0.6, "name")
+	case similarity(name, other) >= 0.85:
+		add(0.5, "similar name")
+	}
+
+	return m
+}
+
+// bestContactMatch returns the highest scoring contact, or nil when none
+// reaches the review threshold.
+func bestContactMatch(contacts []moneybird.Contact, data *extraction.InvoiceData, senderDomain string) *contactMatch {
+	var best *contactMatch
+	for i := range contacts {
+		m := scoreContact(&contacts[i], data, senderDomain)
+		if best == nil || m.score > best.score {
+			best = m
+		}
+	}
+
+	if best == nil || best.score < matchReview {
+		return nil
+	}
+	return best
+}
+
+var legalSuffixes = map[string]bool{
+	"bv": true, "nv": true, "vof": true, "cv": true, "bvba": true, "eenmanszaak": true,
+	"gmbh": true, "ag": true, "kg": true, "ug": true, "ohg": true, "ev": true,
+	"ltd": true, "limited": true, "llc": true, "llp": true, "plc": true, "inc": true,
+	"corp": true, "corporation": true, "co": true, "company": true,
+	"sa": true, "sarl": true, "sas": true, "srl": true, "spa": true, "sl": true,
+	"oy": true, "ab": true, "as": true, "aps": true,
+}
+
+// normalizeCompanyName lowercases the name, drops punctuation and legal
+// suffixes such as B.V., GmbH and Ltd.
+func normalizeCompanyName(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(name, ".", ""))
+	fields := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	kept := fields[:0]
+	for _, field := range fields {
+		if !legalSuffixes[field] {
+			kept = append(kept, field)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// similarity is the Levenshtein distance scaled to 0 (different) - 1 (equal).
+func similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return 1 - float64(prev[len(rb)])/float64(max(len(ra), len(rb)))
+}
+
+func emailDomain(address string) string {
+	if parsed, err := mail.ParseAddress(address); err == nil {
+		address = parsed.Address
+	}
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.ToLower(strings.TrimSpace(address[i+1:]))
+	}
+	return ""
+}
+
+var freemailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "outlook.com": true, "hotmail.com": true,
+	"live.com": true, "live.nl": true, "hotmail.nl": true, "yahoo.com": true,
+	"icloud.com": true, "me.com": true, "proton.me": true, "protonmail.com": true,
+	"ziggo.nl": true, "kpnmail.nl": true, "planet.nl": true, "hetnet.nl": true, "home.nl": true,
+}
+
+func isFreemail(domain string) bool {
+	return freemailDomains[domain]
+}
@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/gmail"
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+)
+
+func attachmentHashes(email gmail.Email) []string {
+	var hashes []string
+	for _, att := range email.Files() {
+		hashes = append(hashes, attachmentHash(att))
+	}
+	return hashes
+}
+
+func attachmentHash(att gmail.Attachment) string {
+	sum := sha256.Sum256(att.Data)
+	return hex.EncodeToString(sum[:])
+}
+
+// findBookedAttachment returns the record of another email with a document
+// booked from the attachment with hash.
+func findBookedAttachment(st *store.Store, messageID, hash string) (*store.Record, *store.Document, error) {
+	matches, err := st.List(func(r *store.Record) bool {
+		return r.MessageID != messageID && r.BookedDocument(hash) != nil
+	})
+	if err != nil || len(matches) == 0 {
+		return nil, nil, err
+	}
+
+	return &matches[0], matches[0].BookedDocument(hash), nil
+}
+
+// matchPurchaseInvoice looks for an existing invoice with the same reference.
+// Only documents without a reference fall back to the same date and total, so
+// two invoices for the same amount on one day are not taken for one.
+func matchPurchaseInvoice(invoices []moneybird.PurchaseInvoice, data *extraction.InvoiceData) *moneybird.PurchaseInvoice {
+	reference := normalizeReference(data.InvoiceNumber)
+	if reference != "" {
+		for i := range invoices {
+			if normalizeReference(invoices[i].Reference) == reference {
+				return &invoices[i]
+			}
+		}
+		return nil
+	}
+
+	for i := range invoices {
+		if invoices[i].Date != data.InvoiceDate {
+			continue
+		}
+		if invoices[i].Currency != "" && data.Currency != "" && invoices[i].Currency != data.Currency {
+			continue
+		}
+		if invoices[i].TotalPriceInclTax == data.TotalAmount {
+			return &invoices[i]
+		}
+	}
+
+	return nil
+}
+
+// documentReference is the Moneybird reference of a document: its number,
+// or its date for receipts without one.
+func documentReference(data *extraction.InvoiceData) string {
+	if data.InvoiceNumber == "" && data.DocumentType == extraction.DocumentReceipt {
+		return "Receipt " + data.InvoiceDate
+	}
+	return data.InvoiceNumber
+}
+
+// normalizeReference drops case, spaces and punctuation so "INV-2024/001"
+// and "inv 2024 001" compare equal.
+func normalizeReference(reference string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, reference)
+}
@@ -2,57 +2,145 @@ package processor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"time"
 
 	"github.com/janyksteenbeek/birdgpt/config"
 	"github.com/janyksteenbeek/birdgpt/internal/gmail"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+	"google.golang.org/api/googleapi"
 )
 
+// EmailProcessor follows the Gmail history for messages that get the search
+// label and records them in the ledger. A full label listing, bounded by the
+// last sync, is only done on the first run and when the stored history ID has
+// expired.
 type EmailProcessor struct {
-	cfg        *config.Config
-	gmail      *gmail.Client
-	lastUpdate time.Time
+	cfg   *config.Config
+	gmail *gmail.Client
+	store *store.Store
 }
 
-func NewEmailProcessor(cfg *config.Config, gmailClient *gmail.Client) *EmailProcessor {
-	lastUpdate, err := time.Parse(time.RFC3339, cfg.App.LastUpdate)
-	if err != nil {
-		log.Fatal("Error parsing last update time: %v", err)
-	}
-
+func NewEmailProcessor(cfg *config.Config, gmailClient *gmail.Client, st *store.Store) *EmailProcessor {
 	return &EmailProcessor{
-		cfg:        cfg,
-		gmail:      gmailClient,
-		lastUpdate: lastUpdate,
+		cfg:   cfg,
+		gmail: gmailClient,
+		store: st,
 	}
 }
 
+// ProcessEmails syncs new messages into the ledger and returns the emails of
+// the records that are due, oldest first and capped at max_per_cycle.
 func (p *EmailProcessor) ProcessEmails(ctx context.Context) ([]gmail.Email, error) {
-	log.Printf("Checking for new emails since %v...", p.lastUpdate.Format(time.RFC3339))
-	emails, err := p.gmail.FetchEmails(ctx, p.cfg.Gmail.SearchLabel, p.lastUpdate)
-	if err != nil {
+	if err := p.sync(ctx); err != nil {
 		return nil, fmt.Errorf("failed to fetch emails: %w", err)
 	}
 
+	// Processing records were interrupted by a crash or shutdown, failed
+	// records are due once their backoff has passed.
+	now := time.Now()
+	due, err := p.store.List(func(r *store.Record) bool { return r.Due(now) })
+	if err != nil {
+		return nil, err
+	}
+
+	if limit := p.cfg.Gmail.MaxPerCycle; limit > 0 && len(due) > limit {
+		log.Printf("%d emails due, processing the oldest %d this cycle", len(due), limit)
+		due = due[:limit]
+	}
+
+	emails := make([]gmail.Email, 0, len(due))
+	for _, record := range due {
+		email, err := p.gmail.FetchEmail(ctx, record.MessageID)
+		if isNotFound(err) {
+			log.Printf("Email %s no longer exists, skipping", record.MessageID)
+			record.Status = store.StatusSkipped
+			record.LastError = "message no longer exists"
+			if err := p.store.Put(&record); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch emails: %w", err)
+		}
+		emails = append(emails, *email)
+	}
+
 	if len(emails) == 0 {
 		log.Println("No new emails found")
 		return nil, nil
 	}
 
-	log.Printf("Found %d new emails", len(emails))
+	log.Printf("Found %d emails to process", len(emails))
 	return emails, nil
 }
 
-func (p *EmailProcessor) UpdateLastProcessed() error {
-   p.lastUpdate = time.Now()
-   p.cfg.App.LastUpdate = p.lastUpdate.Format(time.RFC3339)
-   
-   if err := config.SaveConfig(p.cfg); err != nil {
-	   return fmt.Errorf("failed to save config: %w", err)
-   }
-   
-   log.Printf("Updated last processed time to: %v", p.lastUpdate.Format(time.RFC3339))
-   return nil
-} 
\ No newline at end of file
+func (p *EmailProcessor) sync(ctx context.Context) error {
+	historyID, lastSync, err := p.store.SyncState()
+	if err != nil {
+		return err
+	}
+
+	labelID, err := p.gmail.LabelID(ctx, p.cfg.Gmail.SearchLabel, false)
+	if err != nil {
+		return err
+	}
+
+	syncStart := time.Now()
+
+	if historyID != 0 {
+		ids, newHistoryID, err := p.gmail.LabelHistory(ctx, historyID, labelID)
+		if err == nil {
+			return p.record(ids, newHistoryID, syncStart)
+		}
+		if !errors.Is(err, gmail.ErrHistoryExpired) {
+			return err
+		}
+		log.Printf("Gmail history %d has expired, doing a full resync", historyID)
+	}
+
+	if lastSync.IsZero() {
+		lastSync, err = time.Parse(time.RFC3339, p.cfg.App.LastUpdate)
+		if err != nil {
+			return fmt.Errorf("parsing last_update: %w", err)
+		}
+	}
+
+	// Take the history ID before listing so nothing slips in between.
+	newHistoryID, err := p.gmail.HistoryID(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Listing emails labelled %s since %v...", p.cfg.Gmail.SearchLabel, lastSync.Format(time.RFC3339))
+	query := fmt.Sprintf("label:%s after:%d", p.cfg.Gmail.SearchLabel, lastSync.Unix())
+	ids, err := p.gmail.ListMessageIDs(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return p.record(ids, newHistoryID, syncStart)
+}
+
+// record adds the synced messages to the ledger before moving the sync
+// position, so a crash in between never loses a message.
+func (p *EmailProcessor) record(ids []string, historyID uint64, syncedAt time.Time) error {
+	added, err := p.store.Enqueue(ids)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		log.Printf("Queued %d new emails", added)
+	}
+
+	return p.store.SetSyncState(historyID, syncedAt)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
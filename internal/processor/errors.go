@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/janyksteenbeek/birdgpt/internal/extraction/anthropic"
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+	goopenai "github.com/sashabaranov/go-openai"
+	"google.golang.org/api/googleapi"
+)
+
+// permanentError marks a failure that will not go away by retrying, such as
+// extracted data that fails validation.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// isTransient reports whether a failed email should be retried later. Network
+// errors, timeouts, rate limits and server errors are transient, as is
+// anything unknown; explicit permanent errors and other 4xx responses are not.
+func isTransient(err error) bool {
+	var permanentErr *permanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var mbErr *moneybird.APIError
+	if errors.As(err, &mbErr) {
+		return mbErr.Temporary()
+	}
+
+	var anthropicErr *anthropic.APIError
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.Temporary()
+	}
+
+	var openaiErr *goopenai.APIError
+	if errors.As(err, &openaiErr) {
+		return isTransientStatus(openaiErr.HTTPStatusCode)
+	}
+
+	var requestErr *goopenai.RequestError
+	if errors.As(err, &requestErr) {
+		return isTransientStatus(requestErr.HTTPStatusCode)
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return isTransientStatus(googleErr.Code)
+	}
+
+	return true
+}
+
+func isTransientStatus(code int) bool {
+	return code == 0 || code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}
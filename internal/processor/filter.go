@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/gmail"
+	"github.com/janyksteenbeek/birdgpt/internal/rules"
+)
+
+// Filter rejects emails that are not worth sending to the model, so only
+// likely invoices cost tokens.
+type Filter struct {
+	cfg *config.Config
+}
+
+func NewFilter(cfg *config.Config) *Filter {
+	return &Filter{
+		cfg: cfg,
+	}
+}
+
+// Check returns the name of the rule that rejects the email, or an empty
+// string when the email may be processed. Denied senders and size limits
+// always apply; allowed senders skip the PDF and keyword checks.
+func (f *Filter) Check(email gmail.Email) string {
+	filter := f.cfg.Filter
+	sender := rules.SenderAddress(email.From)
+
+	if matchesSender(sender, filter.SenderDeny) {
+		return "sender_deny"
+	}
+
+	size := int64(len(email.TextBody) + len(email.HTMLBody))
+	for _, att := range email.Attachments {
+		if filter.MaxAttachmentSize > 0 && att.Size > filter.MaxAttachmentSize {
+			return "max_attachment_size"
+		}
+		size += att.Size
+	}
+	if filter.MaxEmailSize > 0 && size > filter.MaxEmailSize {
+		return "max_email_size"
+	}
+
+	if matchesSender(sender, filter.SenderAllow) {
+		return ""
+	}
+
+	if filter.RequirePDF && !hasPDF(email) {
+		return "require_pdf"
+	}
+
+	// The legacy trigger word is one more keyword, so emails with any of
+	// the keywords pass even when they lack the trigger word.
+	keywords := filter.Keywords
+	if f.cfg.App.TriggerWord != "" {
+		keywords = append([]string{f.cfg.App.TriggerWord}, keywords...)
+	}
+	if len(keywords) > 0 && !containsKeyword(email, keywords) {
+		return "keywords"
+	}
+
+	return ""
+}
+
+// matchesSender reports whether the address is listed, either in full or by
+// its domain (with or without a leading @). Subdomains match their parent.
+func matchesSender(address string, list []string) bool {
+	domain := emailDomain(address)
+	for _, entry := range list {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if strings.Contains(strings.TrimPrefix(entry, "@"), "@") {
+			if entry == address {
+				return true
+			}
+			continue
+		}
+
+		entry = strings.TrimPrefix(entry, "@")
+		if domain != "" && (domain == entry || strings.HasSuffix(domain, "."+entry)) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPDF(email gmail.Email) bool {
+	for _, file := range email.Files() {
+		if file.MimeType == "application/pdf" || strings.EqualFold(filepath.Ext(file.Filename), ".pdf") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsKeyword looks for any keyword in the subject, the body and the
+// attachment names, ignoring case.
+func containsKeyword(email gmail.Email, keywords []string) bool {
+	texts := []string{email.Subject, email.Body()}
+	for _, file := range email.Files() {
+		texts = append(texts, file.Filename)
+	}
+
+	for _, text := range texts {
+		text = strings.ToLower(text)
+		for _, keyword := range keywords {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" && strings.Contains(text, keyword) {
+				return true
+			}
+		}
+	}
+	return false
+}
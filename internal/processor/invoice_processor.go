@@ -7,43 +7,111 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
 	"github.com/janyksteenbeek/birdgpt/internal/gmail"
-	"github.com/janyksteenbeek/birdgpt/internal/openai"
+	"github.com/janyksteenbeek/birdgpt/internal/money"
+	"github.com/janyksteenbeek/birdgpt/internal/rules"
+	"golang.org/x/text/currency"
 )
 
 type InvoiceProcessor struct {
-	openai *openai.Client
+	cfg       *config.Config
+	extractor extraction.Extractor
 }
 
-func NewInvoiceProcessor(openaiClient *openai.Client) *InvoiceProcessor {
+func NewInvoiceProcessor(cfg *config.Config, extractor extraction.Extractor) *InvoiceProcessor {
 	return &InvoiceProcessor{
-		openai: openaiClient,
+		cfg:       cfg,
+		extractor: extractor,
 	}
 }
 
-func (p *InvoiceProcessor) ProcessEmail(ctx context.Context, email gmail.Email) (*openai.InvoiceData, error) {
+// ProcessEmail extracts the invoices in the email. Each invoice still has to
+// pass Validate before it is booked.
+func (p *InvoiceProcessor) ProcessEmail(ctx context.Context, email gmail.Email) ([]*extraction.InvoiceData, error) {
 	log.Printf("Processing email: %s - %s", email.Subject, email.From)
-	
-	invoiceData, err := p.openai.ProcessInvoice(ctx, email.Body, email.Attachments)
+
+	// Inline parts are mostly logos and signatures, only real files can hold an invoice.
+	input := extraction.Input{Body: email.Body()}
+	for _, att := range email.Files() {
+		input.Attachments = append(input.Attachments, extraction.Attachment{
+			Filename: att.Filename,
+			MimeType: att.MimeType,
+			Data:     att.Data,
+		})
+	}
+
+	invoices, err := p.extractor.Extract(ctx, input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to process with OpenAI: %w", err)
+		return nil, fmt.Errorf("failed to extract invoice: %w", err)
 	}
 
-	if !invoiceData.IsInvoice {
+	if len(invoices) == 0 {
 		log.Printf("Email is not an invoice: %s", email.Subject)
 		return nil, nil
 	}
 
-	log.Printf("Invoice detected: %s - %s - €%.2f", invoiceData.CompanyName, invoiceData.InvoiceNumber, invoiceData.TotalAmount)
+	for _, invoiceData := range invoices {
+		log.Printf("Invoice detected: %s - %s - %s", invoiceData.CompanyName, invoiceData.InvoiceNumber, money.Money{Amount: invoiceData.TotalAmount, Currency: invoiceData.Currency})
+	}
+
+	return invoices, nil
+}
 
+// Validate checks the extracted invoice. Invalid data will not improve by
+// retrying, so the error is permanent.
+func (p *InvoiceProcessor) Validate(invoiceData *extraction.InvoiceData) error {
 	if err := p.validateInvoiceData(invoiceData); err != nil {
-		return nil, fmt.Errorf("invoice validation failed: %w", err)
+		return permanent(fmt.Errorf("invoice validation failed: %w", err))
+	}
+	return nil
+}
+
+// ApplyRule applies the line overrides of a vendor rule to the extracted
+// invoice. Contact, ledger and review settings are applied when booking.
+func (p *InvoiceProcessor) ApplyRule(invoice *extraction.InvoiceData, rule *rules.Rule) {
+	if rule.TaxRate != nil {
+		for i := range invoice.Items {
+			invoice.Items[i].TaxRate = *rule.TaxRate
+		}
+	}
+
+	if rule.CollapseLines {
+		invoice.Items = collapseItems(invoice)
+	}
+}
+
+// collapseItems merges the items into a single line per tax rate, keeping the
+// category and line type of the first item at that rate.
+func collapseItems(invoice *extraction.InvoiceData) []extraction.InvoiceItem {
+	var collapsed []extraction.InvoiceItem
+	index := make(map[float64]int)
+	for _, item := range invoice.Items {
+		i, ok := index[item.TaxRate]
+		if !ok {
+			i = len(collapsed)
+			index[item.TaxRate] = i
+			collapsed = append(collapsed, extraction.InvoiceItem{
+				Description: fmt.Sprintf("%s %s", invoice.CompanyName, documentReference(invoice)),
+				TaxRate:     item.TaxRate,
+				LineType:    item.LineType,
+				Category:    item.Category,
+			})
+		}
+		collapsed[i].Amount += item.Amount
+	}
+
+	if len(collapsed) > 1 {
+		for i := range collapsed {
+			collapsed[i].Description += fmt.Sprintf(" (%g%%)", collapsed[i].TaxRate)
+		}
 	}
 
-	return invoiceData, nil
+	return collapsed
 }
 
-func (p *InvoiceProcessor) validateInvoiceData(invoice *openai.InvoiceData) error {
+func (p *InvoiceProcessor) validateInvoiceData(invoice *extraction.InvoiceData) error {
 	if !invoice.IsInvoice {
 		return fmt.Errorf("not an invoice")
 	}
@@ -52,12 +120,30 @@ func (p *InvoiceProcessor) validateInvoiceData(invoice *openai.InvoiceData) erro
 		return fmt.Errorf("company name is required")
 	}
 
-	if invoice.InvoiceNumber == "" {
+	invoice.DocumentType = strings.ToLower(strings.TrimSpace(invoice.DocumentType))
+	switch invoice.DocumentType {
+	case extraction.DocumentInvoice, extraction.DocumentCreditNote, extraction.DocumentReceipt:
+	default:
+		return fmt.Errorf("unknown document type: %s", invoice.DocumentType)
+	}
+	creditNote := invoice.DocumentType == extraction.DocumentCreditNote
+
+	// Till receipts often have no number; they are booked by date instead.
+	if invoice.InvoiceNumber == "" && invoice.DocumentType != extraction.DocumentReceipt {
 		return fmt.Errorf("invoice number is required")
 	}
 
-	if invoice.TotalAmount <= 0 {
-		return fmt.Errorf("invalid total amount: %.2f", invoice.TotalAmount)
+	// Credit notes are booked with negative amounts, also when they are
+	// printed as positive amounts under a credit heading.
+	if creditNote {
+		creditAmounts(invoice)
+	}
+
+	if creditNote && invoice.TotalAmount >= 0 {
+		return fmt.Errorf("invalid credit note total amount: %s", invoice.TotalAmount)
+	}
+	if !creditNote && invoice.TotalAmount <= 0 {
+		return fmt.Errorf("invalid total amount: %s", invoice.TotalAmount)
 	}
 
 	if len(invoice.Items) == 0 {
@@ -80,21 +166,123 @@ func (p *InvoiceProcessor) validateInvoiceData(invoice *openai.InvoiceData) erro
 		invoice.ContactInfo.Country = strings.ToUpper(invoice.ContactInfo.Country)
 	}
 
-	// Validate total amount matches sum of items
-	var total float64
-	for _, item := range invoice.Items {
-		if item.Amount <= 0 {
-			return fmt.Errorf("invalid item amount: %.2f", item.Amount)
+	// Validate currency code, invoices without one are in the administration currency
+	if invoice.Currency == "" {
+		invoice.Currency = p.cfg.Moneybird.Currency
+	}
+	unit, err := currency.ParseISO(invoice.Currency)
+	if err != nil {
+		return fmt.Errorf("invalid currency: %s", invoice.Currency)
+	}
+	invoice.Currency = unit.String()
+
+	for i := range invoice.Items {
+		item := &invoice.Items[i]
+		if err := normalizeItem(item); err != nil {
+			return err
+		}
+		if item.Amount == 0 || (item.Amount < 0) != creditNote {
+			return fmt.Errorf("invalid item amount: %s", item.Amount)
 		}
 		if item.TaxRate < 0 {
 			return fmt.Errorf("invalid tax rate: %.2f", item.TaxRate)
 		}
-		total += item.Amount
 	}
 
-	if total != invoice.TotalAmount {
-		return fmt.Errorf("total amount (%.2f) does not match sum of items (%.2f)", invoice.TotalAmount, total)
+	return reconcileTax(invoice)
+}
+
+// normalizeItem defaults the quantity to one and checks the line total
+// against the quantity, unit price and discount, deriving it when missing.
+func normalizeItem(item *extraction.InvoiceItem) error {
+	if item.Quantity < 0 {
+		return fmt.Errorf("invalid quantity for %q: %g", item.Description, item.Quantity)
+	}
+	if item.Quantity == 0 {
+		item.Quantity = 1
+	}
+	if item.UnitPrice == 0 {
+		return nil
 	}
 
+	expected := money.FromFloat(item.Quantity*item.UnitPrice) - item.Discount
+	if item.Amount == 0 {
+		item.Amount = expected
+	}
+	if !money.Within(expected, item.Amount, 1) {
+		return fmt.Errorf("amount of %q (%s) does not match %g x %g less %s discount", item.Description, item.Amount, item.Quantity, item.UnitPrice, item.Discount)
+	}
 	return nil
-} 
\ No newline at end of file
+}
+
+// reconcileTax checks the items against the total and tax amount. Models
+// regularly get the net/gross flag wrong, so when the items only add up the
+// other way around, the flag is corrected.
+func reconcileTax(invoice *extraction.InvoiceData) error {
+	// Lines and their VAT may each be rounded to the cent on the invoice.
+	tolerance := lineTolerance(len(invoice.Items))
+	if itemsMatch(invoice, invoice.PricesInclTax, tolerance) {
+		return nil
+	}
+	if itemsMatch(invoice, !invoice.PricesInclTax, tolerance) {
+		log.Printf("Items of invoice %s only add up %s VAT, correcting", invoice.InvoiceNumber, inclusion(!invoice.PricesInclTax))
+		invoice.PricesInclTax = !invoice.PricesInclTax
+		return nil
+	}
+
+	total, tax := itemTotals(invoice.Items, invoice.PricesInclTax)
+	return fmt.Errorf("total amount (%s, VAT %s) does not match sum of items (%s, VAT %s)", invoice.TotalAmount, invoice.TaxAmount, total, tax)
+}
+
+// itemsMatch reports whether the items add up to the total, and to the tax
+// amount when the invoice has one, when read as gross or net prices.
+func itemsMatch(invoice *extraction.InvoiceData, inclTax bool, tolerance money.Amount) bool {
+	total, tax := itemTotals(invoice.Items, inclTax)
+	if !money.Within(total, invoice.TotalAmount, tolerance) {
+		return false
+	}
+	return invoice.TaxAmount == 0 || money.Within(tax, invoice.TaxAmount, tolerance)
+}
+
+// itemTotals sums the items into a total including VAT and the VAT in it.
+func itemTotals(items []extraction.InvoiceItem, inclTax bool) (total, tax money.Amount) {
+	for _, item := range items {
+		rate := item.TaxRate / 100
+		if inclTax {
+			lineTax := item.Amount - money.FromFloat(item.Amount.Float64()/(1+rate))
+			total += item.Amount
+			tax += lineTax
+		} else {
+			lineTax := money.FromFloat(item.Amount.Float64() * rate)
+			total += item.Amount + lineTax
+			tax += lineTax
+		}
+	}
+	return total, tax
+}
+
+func inclusion(inclTax bool) string {
+	if inclTax {
+		return "including"
+	}
+	return "excluding"
+}
+
+// creditAmounts makes the total, tax and items of a credit note negative.
+// Each is checked on its own, as the model does not always carry the sign of
+// the total over to the lines.
+func creditAmounts(invoice *extraction.InvoiceData) {
+	invoice.TotalAmount = -invoice.TotalAmount.Abs()
+	invoice.TaxAmount = -invoice.TaxAmount.Abs()
+	for i := range invoice.Items {
+		item := &invoice.Items[i]
+		if item.Amount > 0 || (item.Amount == 0 && item.UnitPrice > 0) {
+			item.Amount, item.UnitPrice, item.Discount = -item.Amount, -item.UnitPrice, -item.Discount
+		}
+	}
+}
+
+// lineTolerance allows a rounding difference of a cent per line.
+func lineTolerance(lines int) money.Amount {
+	return money.Amount(lines)
+}
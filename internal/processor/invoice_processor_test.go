@@ -0,0 +1,245 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/money"
+)
+
+func TestNormalizeItem(t *testing.T) {
+	tests := []struct {
+		name     string
+		item     extraction.InvoiceItem
+		quantity float64
+		amount   money.Amount
+		wantErr  bool
+	}{
+		{
+			name:     "no quantity or unit price",
+			item:     extraction.InvoiceItem{Amount: 1250},
+			quantity: 1, amount: 1250,
+		},
+		{
+			name:     "quantity times unit price",
+			item:     extraction.InvoiceItem{Quantity: 3, UnitPrice: 10, Amount: 3000},
+			quantity: 3, amount: 3000,
+		},
+		{
+			name:     "missing amount is derived",
+			item:     extraction.InvoiceItem{Quantity: 3, UnitPrice: 10},
+			quantity: 3, amount: 3000,
+		},
+		{
+			name:     "discount",
+			item:     extraction.InvoiceItem{Quantity: 2, UnitPrice: 10, Discount: 500, Amount: 1500},
+			quantity: 2, amount: 1500,
+		},
+		{
+			name:     "fractional quantity rounded to the cent",
+			item:     extraction.InvoiceItem{Quantity: 1.5, UnitPrice: 3.33},
+			quantity: 1.5, amount: 500,
+		},
+		{
+			name:     "a cent of rounding on the invoice",
+			item:     extraction.InvoiceItem{Quantity: 1.5, UnitPrice: 3.33, Amount: 499},
+			quantity: 1.5, amount: 499,
+		},
+		{
+			name:     "usage price below the cent",
+			item:     extraction.InvoiceItem{Quantity: 720, UnitPrice: 0.0116, Amount: 835},
+			quantity: 720, amount: 835,
+		},
+		{
+			name:     "fuel price per litre",
+			item:     extraction.InvoiceItem{Quantity: 40.12, UnitPrice: 1.949, Amount: 7819},
+			quantity: 40.12, amount: 7819,
+		},
+		{
+			name:     "unit price without quantity",
+			item:     extraction.InvoiceItem{UnitPrice: 10, Amount: 1000},
+			quantity: 1, amount: 1000,
+		},
+		{
+			name:     "credit note",
+			item:     extraction.InvoiceItem{Quantity: 2, UnitPrice: -10, Discount: -500, Amount: -1500},
+			quantity: 2, amount: -1500,
+		},
+		{
+			name:    "amount does not match",
+			item:    extraction.InvoiceItem{Quantity: 2, UnitPrice: 10, Amount: 2500},
+			wantErr: true,
+		},
+		{
+			name:    "negative quantity",
+			item:    extraction.InvoiceItem{Quantity: -1, UnitPrice: 10, Amount: -1000},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := tt.item
+			err := normalizeItem(&item)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if item.Quantity != tt.quantity || item.Amount != tt.amount {
+				t.Errorf("got %g x = %s, want %g x = %s", item.Quantity, item.Amount, tt.quantity, tt.amount)
+			}
+		})
+	}
+}
+
+func TestReconcileTax(t *testing.T) {
+	lines := func(amount money.Amount, rate float64, n int) []extraction.InvoiceItem {
+		items := make([]extraction.InvoiceItem, n)
+		for i := range items {
+			items[i] = extraction.InvoiceItem{Amount: amount, TaxRate: rate}
+		}
+		return items
+	}
+
+	tests := []struct {
+		name    string
+		invoice extraction.InvoiceData
+		inclTax bool
+		wantErr bool
+	}{
+		{
+			name:    "net prices",
+			invoice: extraction.InvoiceData{Items: lines(10000, 21, 1), TotalAmount: 12100, TaxAmount: 2100},
+		},
+		{
+			name:    "gross prices",
+			invoice: extraction.InvoiceData{Items: lines(12100, 21, 1), PricesInclTax: true, TotalAmount: 12100, TaxAmount: 2100},
+			inclTax: true,
+		},
+		{
+			name: "mixed rates",
+			invoice: extraction.InvoiceData{
+				Items:       []extraction.InvoiceItem{{Amount: 10000, TaxRate: 21}, {Amount: 5000, TaxRate: 9}},
+				TotalAmount: 17550,
+				TaxAmount:   2550,
+			},
+		},
+		{
+			name:    "without tax amount",
+			invoice: extraction.InvoiceData{Items: lines(10000, 21, 1), TotalAmount: 12100},
+		},
+		{
+			name:    "net prices flagged as gross",
+			invoice: extraction.InvoiceData{Items: lines(10000, 21, 1), PricesInclTax: true, TotalAmount: 12100, TaxAmount: 2100},
+		},
+		{
+			name:    "gross prices flagged as net",
+			invoice: extraction.InvoiceData{Items: lines(12100, 21, 1), TotalAmount: 12100, TaxAmount: 2100},
+			inclTax: true,
+		},
+		{
+			// VAT rounded per line adds up to 0.10, on the total to 0.11.
+			name:    "a cent of rounding per line",
+			invoice: extraction.InvoiceData{Items: lines(5, 21, 10), TotalAmount: 61, TaxAmount: 11},
+		},
+		{
+			name:    "credit note",
+			invoice: extraction.InvoiceData{Items: lines(-10000, 21, 1), TotalAmount: -12100, TaxAmount: -2100},
+		},
+		{
+			name:    "total does not match",
+			invoice: extraction.InvoiceData{Items: lines(10000, 21, 1), TotalAmount: 15000},
+			wantErr: true,
+		},
+		{
+			name:    "tax amount does not match",
+			invoice: extraction.InvoiceData{Items: lines(10000, 21, 1), TotalAmount: 12100, TaxAmount: 3000},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := tt.invoice
+			err := reconcileTax(&invoice)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if invoice.PricesInclTax != tt.inclTax {
+				t.Errorf("prices including VAT = %t, want %t", invoice.PricesInclTax, tt.inclTax)
+			}
+		})
+	}
+}
+
+func TestValidateCreditNoteSigns(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Moneybird.Currency = "EUR"
+	p := NewInvoiceProcessor(cfg, nil)
+
+	tests := []struct {
+		name  string
+		total money.Amount
+		tax   money.Amount
+		items []extraction.InvoiceItem
+	}{
+		{
+			name: "printed as positive amounts", total: 12100, tax: 2100,
+			items: []extraction.InvoiceItem{{Amount: 10000, TaxRate: 21}},
+		},
+		{
+			name: "negative total with positive lines", total: -12100, tax: -2100,
+			items: []extraction.InvoiceItem{{Amount: 10000, TaxRate: 21}},
+		},
+		{
+			name: "negative total and tax with positive unit prices", total: -12100, tax: 2100,
+			items: []extraction.InvoiceItem{{Quantity: 2, UnitPrice: 50, TaxRate: 21}},
+		},
+		{
+			name: "mixed signs on the lines", total: -18150,
+			items: []extraction.InvoiceItem{{Amount: -10000, TaxRate: 21}, {Amount: 5000, TaxRate: 21}},
+		},
+		{
+			name: "already negative", total: -12100, tax: -2100,
+			items: []extraction.InvoiceItem{{Quantity: 2, UnitPrice: -50, Amount: -10000, TaxRate: 21}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := &extraction.InvoiceData{
+				IsInvoice:     true,
+				DocumentType:  extraction.DocumentCreditNote,
+				CompanyName:   "Example B.V.",
+				InvoiceNumber: "CN-1",
+				TotalAmount:   tt.total,
+				TaxAmount:     tt.tax,
+				Items:         tt.items,
+			}
+			if err := p.validateInvoiceData(invoice); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if invoice.TotalAmount >= 0 || invoice.TaxAmount > 0 {
+				t.Errorf("got total %s and VAT %s, want them negative", invoice.TotalAmount, invoice.TaxAmount)
+			}
+			for _, item := range invoice.Items {
+				if item.Amount >= 0 || item.UnitPrice > 0 {
+					t.Errorf("got line of %s at %g, want it negative", item.Amount, item.UnitPrice)
+				}
+			}
+		})
+	}
+}
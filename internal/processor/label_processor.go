@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/gmail"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+)
+
+// Outcome is what happened to a single email during a processing cycle.
+type Outcome string
+
+const (
+	OutcomeBooked  Outcome = "booked"
+	OutcomeSkipped Outcome = "skipped"
+	OutcomeFailed  Outcome = "failed"
+	OutcomeReview  Outcome = "review"
+)
+
+func outcomeOf(status store.Status) Outcome {
+	switch status {
+	case store.StatusBooked:
+		return OutcomeBooked
+	case store.StatusSkipped:
+		return OutcomeSkipped
+	case store.StatusReview:
+		return OutcomeReview
+	default:
+		return OutcomeFailed
+	}
+}
+
+func (o Outcome) status() store.Status {
+	switch o {
+	case OutcomeBooked:
+		return store.StatusBooked
+	case OutcomeSkipped:
+		return store.StatusSkipped
+	case OutcomeReview:
+		return store.StatusReview
+	default:
+		return store.StatusFailed
+	}
+}
+
+type LabelProcessor struct {
+	cfg   *config.Config
+	gmail *gmail.Client
+}
+
+func NewLabelProcessor(cfg *config.Config, gmailClient *gmail.Client) *LabelProcessor {
+	return &LabelProcessor{
+		cfg:   cfg,
+		gmail: gmailClient,
+	}
+}
+
+// Apply labels the email with its outcome and the tags of its vendor rule.
+// Booked and skipped emails are done, so they can lose the trigger label and
+// be archived; failed ones and those waiting for review stay put.
+func (p *LabelProcessor) Apply(ctx context.Context, email gmail.Email, outcome Outcome, tags []string) error {
+	labels := p.cfg.Gmail.Labels
+	if !labels.Enabled {
+		return nil
+	}
+
+	var add, remove []string
+
+	// Swap out the label of an earlier outcome, e.g. when a retry succeeds.
+	names := map[Outcome]string{
+		OutcomeBooked:  labels.Booked,
+		OutcomeSkipped: labels.Skipped,
+		OutcomeFailed:  labels.Failed,
+		OutcomeReview:  labels.Review,
+	}
+	for o, name := range names {
+		if name == "" {
+			continue
+		}
+		id, err := p.gmail.LabelID(ctx, name, true)
+		if err != nil {
+			return err
+		}
+		if o == outcome {
+			add = append(add, id)
+		} else {
+			remove = append(remove, id)
+		}
+	}
+
+	for _, tag := range tags {
+		id, err := p.gmail.LabelID(ctx, tag, true)
+		if err != nil {
+			return err
+		}
+		add = append(add, id)
+	}
+
+	if outcome == OutcomeBooked || outcome == OutcomeSkipped {
+		if labels.RemoveTriggerLabel {
+			id, err := p.gmail.LabelID(ctx, p.cfg.Gmail.SearchLabel, false)
+			if err != nil {
+				return err
+			}
+			remove = append(remove, id)
+		}
+		if labels.Archive {
+			remove = append(remove, "INBOX")
+		}
+	}
+
+	if err := p.gmail.ModifyLabels(ctx, email.ID, add, remove); err != nil {
+		return fmt.Errorf("labelling email as %s: %w", outcome, err)
+	}
+
+	log.Printf("Labelled email %s as %s", email.Subject, outcome)
+	return nil
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+	"github.com/janyksteenbeek/birdgpt/internal/rules"
+)
+
+// ledgerAccountID picks the ledger account for an invoice item. The vendor
+// rule and vendor override win over the category the model suggested, which
+// wins over the configured default. An empty ID leaves the choice to Moneybird.
+func (p *MoneybirdProcessor) ledgerAccountID(contact *moneybird.Contact, data *extraction.InvoiceData, item extraction.InvoiceItem, rule *rules.Rule) (string, error) {
+	ledger := p.cfg.Moneybird.Ledger
+
+	// Fetch up front so lookups below only fail on unknown accounts.
+	if _, err := p.moneybird.LedgerAccounts(); err != nil {
+		return "", fmt.Errorf("failed to fetch ledger accounts: %w", err)
+	}
+
+	var ref string
+	if rule != nil {
+		ref = rule.LedgerAccount
+	}
+	if ref == "" {
+		ref = vendorLedgerAccount(ledger.Vendors, contact, data)
+	}
+	if ref == "" {
+		ref = ledger.Categories[item.Category]
+	}
+	if ref == "" {
+		// Categories can also be named after a ledger account directly.
+		if account, err := p.moneybird.FindLedgerAccount(item.Category); err == nil {
+			return account.ID, nil
+		}
+		ref = ledger.Default
+	}
+	if ref == "" {
+		return "", nil
+	}
+
+	account, err := p.moneybird.FindLedgerAccount(ref)
+	if err != nil {
+		// A misconfigured account will not fix itself by retrying.
+		return "", permanent(fmt.Errorf("resolving ledger account for %q: %w", item.Description, err))
+	}
+
+	log.Printf("Booking %q (%s) on ledger account %s", item.Description, item.Category, account.Name)
+	return account.ID, nil
+}
+
+// vendorLedgerAccount looks up the override configured for the vendor, keyed
+// by Moneybird contact ID, VAT number or company name, in that order. Keys
+// are tried in sorted order so the same invoice always gets the same account.
+func vendorLedgerAccount(vendors map[string]string, contact *moneybird.Contact, data *extraction.InvoiceData) string {
+	keys := slices.Sorted(maps.Keys(vendors))
+
+	if ref, ok := vendors[contact.ID]; ok && contact.ID != "" {
+		return ref
+	}
+
+	for _, key := range keys {
+		vat := normalizeReference(key)
+		if vat != "" && (vat == normalizeReference(data.VatNumber) || vat == normalizeReference(contact.TaxNumber)) {
+			return vendors[key]
+		}
+	}
+
+	for _, key := range keys {
+		name := normalizeCompanyName(key)
+		if name != "" && (name == normalizeCompanyName(data.CompanyName) || name == normalizeCompanyName(contact.CompanyName)) {
+			return vendors[key]
+		}
+	}
+
+	return ""
+}
@@ -4,59 +4,298 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/gmail"
+	"github.com/janyksteenbeek/birdgpt/internal/money"
 	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
-	"github.com/janyksteenbeek/birdgpt/internal/openai"
+	"github.com/janyksteenbeek/birdgpt/internal/render"
+	"github.com/janyksteenbeek/birdgpt/internal/rules"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+	"github.com/janyksteenbeek/birdgpt/internal/tax"
 )
 
 type MoneybirdProcessor struct {
-	cfg       *config.Config
-	moneybird *moneybird.Client
+	cfg        *config.Config
+	moneybird  *moneybird.Client
+	classifier *tax.Classifier
 }
 
-func NewMoneybirdProcessor(cfg *config.Config, moneybirdClient *moneybird.Client) *MoneybirdProcessor {
+func NewMoneybirdProcessor(cfg *config.Config, moneybirdClient *moneybird.Client, classifier *tax.Classifier) *MoneybirdProcessor {
 	return &MoneybirdProcessor{
-		cfg:       cfg,
-		moneybird: moneybirdClient,
+		cfg:        cfg,
+		moneybird:  moneybirdClient,
+		classifier: classifier,
 	}
 }
 
-func (p *MoneybirdProcessor) ProcessInvoice(ctx context.Context, invoiceData *openai.InvoiceData) error {
+// Booking is the result of booking an invoice in Moneybird.
+type Booking struct {
+	ContactID         string
+	PurchaseInvoiceID string
+	// Existing is set when a matching purchase invoice already existed and
+	// nothing was created.
+	Existing bool
+	// Review is set when the contact could not be matched with confidence;
+	// nothing was booked and ReviewReason explains why.
+	Review       bool
+	ReviewReason string
+	// ContactChanges lists the contact fields updated from the invoice. It
+	// is also returned when booking fails after the contact was updated.
+	ContactChanges []store.ContactChange
+	// CreditedInvoiceID is the purchase invoice a credit note corrects, when
+	// it was found.
+	CreditedInvoiceID string
+}
+
+// BookingOptions carries what is known about an invoice besides its content.
+type BookingOptions struct {
+	// Sender is the From header of the email.
+	Sender string
+	// ContactID pins the Moneybird contact, skipping contact matching. It
+	// takes precedence over the contact of the vendor rule.
+	ContactID string
+	// Rule is the vendor rule matching the invoice, if any.
+	Rule *rules.Rule
+	// Approved books the invoice even when the vendor rule asks for review.
+	Approved bool
+	// ApproveVAT books lines with uncertain VAT at their extracted rate.
+	ApproveVAT bool
+}
+
+func (p *MoneybirdProcessor) ProcessInvoice(ctx context.Context, invoiceData *extraction.InvoiceData, opts BookingOptions) (*Booking, error) {
 	log.Printf("Processing invoice for %s", invoiceData.CompanyName)
-	contacts, err := p.moneybird.SearchContacts(invoiceData.CompanyName)
+
+	if opts.Rule != nil {
+		if opts.Rule.Review && !opts.Approved {
+			return &Booking{Review: true, ReviewReason: fmt.Sprintf("vendor rule %s requires review", opts.Rule.Name)}, nil
+		}
+		if opts.ContactID == "" {
+			opts.ContactID = opts.Rule.ContactID
+		}
+	}
+
+	contact, booking, err := p.resolveContact(invoiceData, opts)
+	if err != nil || booking != nil {
+		return booking, err
+	}
+
+	// Enrich before deciding on VAT, which depends on the contact's country
+	// and tax number. Pinned contacts were chosen by hand, so only their
+	// empty fields are filled.
+	policy := p.cfg.Moneybird.ContactEnrichment
+	if opts.ContactID != "" && policy == EnrichOverwrite {
+		policy = EnrichFill
+	}
+	contact, changes, err := p.enrichContact(contact, invoiceData, policy)
 	if err != nil {
-		return fmt.Errorf("failed to search contacts: %w", err)
+		return nil, err
+	}
+
+	// From here on the contact may have changed, so the changes are returned
+	// with errors too.
+	failed := &Booking{ContactID: contact.ID, ContactChanges: changes}
+
+	existing, err := p.moneybird.ListPurchaseInvoices(contact.ID)
+	if err != nil {
+		return failed, fmt.Errorf("failed to list purchase invoices: %w", err)
+	}
+
+	if match := matchPurchaseInvoice(existing, invoiceData); match != nil {
+		log.Printf("Purchase invoice %s (%s) already exists for %s", match.ID, match.Reference, contact.CompanyName)
+		return &Booking{ContactID: contact.ID, PurchaseInvoiceID: match.ID, Existing: true, ContactChanges: changes}, nil
+	}
+
+	decisions, reason := p.classifyVAT(contact, invoiceData, opts)
+	if reason != "" {
+		log.Printf("Invoice %s needs review: %s", invoiceData.InvoiceNumber, reason)
+		return &Booking{ContactID: contact.ID, Review: true, ReviewReason: reason, ContactChanges: changes}, nil
+	}
+
+	invoice, err := p.createPurchaseInvoice(invoiceData, contact, decisions, opts.Rule)
+	if err != nil {
+		return failed, err
+	}
+
+	created, err := p.moneybird.CreatePurchaseInvoice(invoice)
+	if err != nil {
+		return failed, fmt.Errorf("failed to create purchase invoice: %w", err)
+	}
+
+	log.Printf("Successfully created purchase %s for %s (%s)", strings.ReplaceAll(invoiceData.DocumentType, "_", " "), invoiceData.CompanyName, money.Money{Amount: invoiceData.TotalAmount, Currency: invoiceData.Currency})
+	booking = &Booking{ContactID: contact.ID, PurchaseInvoiceID: created.ID, ContactChanges: changes}
+
+	if invoiceData.DocumentType == extraction.DocumentCreditNote {
+		booking.CreditedInvoiceID = p.linkCreditNote(existing, created.ID, invoiceData)
+	}
+
+	return booking, nil
+}
+
+// linkCreditNote looks up the invoice a credit note corrects among the
+// contact's purchase invoices and notes the relation on both. The credit
+// note is already booked, so failures are only logged.
+func (p *MoneybirdProcessor) linkCreditNote(invoices []moneybird.PurchaseInvoice, creditNoteID string, data *extraction.InvoiceData) string {
+	reference := normalizeReference(data.OriginalReference)
+	if reference == "" {
+		return ""
+	}
+
+	var original *moneybird.PurchaseInvoice
+	for i := range invoices {
+		if normalizeReference(invoices[i].Reference) == reference {
+			original = &invoices[i]
+			break
+		}
+	}
+	if original == nil {
+		log.Printf("Invoice %s credited by %s not found in Moneybird", data.OriginalReference, data.InvoiceNumber)
+		return ""
+	}
+
+	log.Printf("Linking credit note %s to purchase invoice %s (%s)", data.InvoiceNumber, original.ID, original.Reference)
+	if err := p.moneybird.AddPurchaseInvoiceNote(creditNoteID, fmt.Sprintf("Credit note for purchase invoice %s", original.Reference)); err != nil {
+		log.Printf("Failed to add note to credit note %s: %v", creditNoteID, err)
+	}
+	if err := p.moneybird.AddPurchaseInvoiceNote(original.ID, fmt.Sprintf("Credited by %s", documentReference(data))); err != nil {
+		log.Printf("Failed to add note to purchase invoice %s: %v", original.ID, err)
+	}
+
+	return original.ID
+}
+
+// AttachSourceDocuments uploads the source files of an invoice to the
+// purchase invoice. Invoices found in the email body get a PDF copy of the
+// email instead, so the source document is always kept. Files whose hash is
+// in uploaded are skipped; the hashes of the files uploaded are added to it
+// and returned, also when an upload fails.
+func (p *MoneybirdProcessor) AttachSourceDocuments(ctx context.Context, invoiceID string, email gmail.Email, files []gmail.Attachment, uploaded []string) ([]string, error) {
+	if len(files) == 0 {
+		if slices.Contains(uploaded, emailCopyKey) {
+			return uploaded, nil
+		}
+		log.Printf("Attaching a PDF copy of email %s to purchase invoice %s", email.Subject, invoiceID)
+		if err := p.moneybird.AddPurchaseInvoiceAttachment(invoiceID, "email.pdf", render.TextPDF(emailDocument(email))); err != nil {
+			return uploaded, err
+		}
+		return append(uploaded, emailCopyKey), nil
+	}
+
+	for i, file := range files {
+		hash := attachmentHash(file)
+		if slices.Contains(uploaded, hash) {
+			continue
+		}
+
+		name := file.Filename
+		if name == "" {
+			name = fmt.Sprintf("attachment-%d%s", i+1, extensionFor(file.MimeType))
+		}
+
+		log.Printf("Attaching %s to purchase invoice %s", name, invoiceID)
+		if err := p.moneybird.AddPurchaseInvoiceAttachment(invoiceID, name, file.Data); err != nil {
+			return uploaded, fmt.Errorf("failed to attach %s: %w", name, err)
+		}
+		uploaded = append(uploaded, hash)
 	}
 
-	var contact *moneybird.Contact
+	return uploaded, nil
+}
+
+// emailCopyKey marks the PDF copy of the email as uploaded.
+const emailCopyKey = "email"
+
+func emailDocument(email gmail.Email) string {
+	date := email.Date
+	if date.IsZero() {
+		date = email.ReceivedAt
+	}
+
+	return fmt.Sprintf("From: %s\nDate: %s\nSubject: %s\n\n%s", email.From, date.Format(time.RFC1123Z), email.Subject, email.Body())
+}
+
+func extensionFor(mimeType string) string {
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
 
-	if len(contacts) == 0 {
-		log.Printf("Creating new contact: %s", invoiceData.CompanyName)
-		contact, err = p.createContact(invoiceData)
+// resolveContact finds the contact for the invoice, creating one when no
+// existing contact matches at all. A booking is returned instead when the
+// best match is too weak to book on.
+func (p *MoneybirdProcessor) resolveContact(data *extraction.InvoiceData, opts BookingOptions) (*moneybird.Contact, *Booking, error) {
+	if opts.ContactID != "" {
+		contact, err := p.moneybird.GetContact(opts.ContactID)
 		if err != nil {
-			return err
+			return nil, nil, fmt.Errorf("failed to get contact %s: %w", opts.ContactID, err)
 		}
-	} else {
-		contact = &contacts[0]
-		log.Printf("Using existing contact: %s", contact.CompanyName)
+		log.Printf("Using pinned contact: %s", contact.CompanyName)
+		return contact, nil, nil
+	}
+
+	candidates, err := p.searchCandidates(data, opts.Sender)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	shouldShiftVAT := p.shouldShiftVAT(contact)
-	if shouldShiftVAT {
-		log.Printf("VAT will be shifted (EU B2B transaction with %s)", contact.Country)
+	match := bestContactMatch(candidates, data, emailDomain(opts.Sender))
+	switch {
+	case match == nil:
+		log.Printf("Creating new contact: %s", data.CompanyName)
+		contact, err := p.createContact(data)
+		return contact, nil, err
+	case match.score < matchConfident:
+		log.Printf("Contact match for %s needs review: %s", data.CompanyName, match)
+		return nil, &Booking{
+			ContactID:    match.contact.ID,
+			Review:       true,
+			ReviewReason: fmt.Sprintf("low confidence contact match %s", match),
+		}, nil
+	default:
+		log.Printf("Using existing contact: %s", match)
+		return match.contact, nil, nil
 	}
+}
 
-	invoice := p.createPurchaseInvoice(invoiceData, contact, shouldShiftVAT)
-	if _, err = p.moneybird.CreatePurchaseInvoice(invoice); err != nil {
-		return fmt.Errorf("failed to create purchase invoice: %w", err)
+// searchCandidates collects contacts matching any of the invoice's identifiers.
+func (p *MoneybirdProcessor) searchCandidates(data *extraction.InvoiceData, sender string) ([]moneybird.Contact, error) {
+	queries := []string{data.CompanyName, data.VatNumber, data.KvkNumber, data.IBAN}
+	if domain := emailDomain(sender); domain != "" && !isFreemail(domain) {
+		queries = append(queries, domain)
 	}
 
-	log.Printf("Successfully created purchase invoice for %s (€%.2f)", invoiceData.CompanyName, invoiceData.TotalAmount)
-	return nil
+	var candidates []moneybird.Contact
+	seen := make(map[string]bool)
+	for _, query := range queries {
+		if strings.TrimSpace(query) == "" {
+			continue
+		}
+
+		contacts, err := p.moneybird.SearchContacts(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search contacts: %w", err)
+		}
+
+		for _, contact := range contacts {
+			if !seen[contact.ID] {
+				seen[contact.ID] = true
+				candidates = append(candidates, contact)
+			}
+		}
+	}
+
+	return candidates, nil
 }
 
-func (p *MoneybirdProcessor) createContact(data *openai.InvoiceData) (*moneybird.Contact, error) {
+func (p *MoneybirdProcessor) createContact(data *extraction.InvoiceData) (*moneybird.Contact, error) {
 	contact := &moneybird.Contact{
 		CompanyName: data.CompanyName,
 		Email:       data.ContactInfo.Email,
@@ -66,6 +305,7 @@ func (p *MoneybirdProcessor) createContact(data *openai.InvoiceData) (*moneybird
 		City:        data.ContactInfo.City,
 		Country:     data.ContactInfo.Country,
 		ZipCode:     data.ContactInfo.ZipCode,
+		SepaIban:    data.IBAN,
 	}
 
 	created, err := p.moneybird.CreateContact(contact)
@@ -76,33 +316,99 @@ func (p *MoneybirdProcessor) createContact(data *openai.InvoiceData) (*moneybird
 	return created, nil
 }
 
-func (p *MoneybirdProcessor) shouldShiftVAT(contact *moneybird.Contact) bool {
-	return moneybird.IsEUCountry(contact.Country) &&
-		contact.Country != p.cfg.Moneybird.Country &&
-		contact.TaxNumber != ""
+// classifyVAT decides the VAT treatment and tax rate of every line. The
+// lines the classifier is not sure about are returned together as the reason
+// for review.
+func (p *MoneybirdProcessor) classifyVAT(contact *moneybird.Contact, data *extraction.InvoiceData, opts BookingOptions) ([]tax.Decision, string) {
+	invoice := tax.Invoice{
+		Country:    contact.Country,
+		VatNumber:  contact.TaxNumber,
+		Note:       data.TaxNote,
+		ApproveVAT: opts.ApproveVAT,
+	}
+	if invoice.Country == "" {
+		invoice.Country = data.ContactInfo.Country
+	}
+	if invoice.VatNumber == "" {
+		invoice.VatNumber = data.VatNumber
+	}
+	if opts.Rule != nil {
+		invoice.Treatment = tax.Treatment(opts.Rule.VAT)
+	}
+	for _, item := range data.Items {
+		invoice.Lines = append(invoice.Lines, tax.Line{
+			Description: item.Description,
+			TaxRate:     item.TaxRate,
+			Type:        item.LineType,
+			Category:    item.Category,
+		})
+	}
+
+	decisions := p.classifier.Classify(invoice)
+
+	var reasons, treatments []string
+	for _, decision := range decisions {
+		if decision.Reason != "" {
+			reasons = append(reasons, decision.Reason)
+		} else if !slices.Contains(treatments, string(decision.Treatment)) {
+			treatments = append(treatments, string(decision.Treatment))
+		}
+	}
+	if len(reasons) > 0 {
+		return nil, "VAT: " + strings.Join(reasons, "; ")
+	}
+
+	log.Printf("VAT treatment of invoice %s: %s", data.InvoiceNumber, strings.Join(treatments, ", "))
+	return decisions, ""
 }
 
-func (p *MoneybirdProcessor) createPurchaseInvoice(data *openai.InvoiceData, contact *moneybird.Contact, shouldShiftVAT bool) *moneybird.PurchaseInvoice {
+func (p *MoneybirdProcessor) createPurchaseInvoice(data *extraction.InvoiceData, contact *moneybird.Contact, decisions []tax.Decision, rule *rules.Rule) (*moneybird.PurchaseInvoice, error) {
 	invoice := &moneybird.PurchaseInvoice{
-		ContactID:  contact.ID,
-		Reference:  data.InvoiceNumber,
-		Date:       data.InvoiceDate,
-		DueDate:    data.DueDate,
-		Details:    make([]moneybird.InvoiceDetail, len(data.Items)),
+		ContactID:        contact.ID,
+		Reference:        documentReference(data),
+		Date:             data.InvoiceDate,
+		DueDate:          data.DueDate,
+		Currency:         data.Currency,
+		Details:          make([]moneybird.InvoiceDetail, len(data.Items)),
+		PricesAreInclTax: data.PricesInclTax,
 	}
 
 	for i, item := range data.Items {
-		taxRateID := p.moneybird.GetTaxRateID(item.TaxRate)
-		if shouldShiftVAT {
-			taxRateID = p.moneybird.GetVATShiftedTaxRateID()
+		ledgerAccountID, err := p.ledgerAccountID(contact, data, item, rule)
+		if err != nil {
+			return nil, err
 		}
 
+		quantity, price := detailPrice(item)
 		invoice.Details[i] = moneybird.InvoiceDetail{
-			Description: item.Description,
-			Price:      item.Amount,
-			TaxRateID:  taxRateID,
+			Description:     item.Description,
+			Amount:          quantity,
+			Price:           price,
+			TaxRateID:       decisions[i].TaxRateID,
+			LedgerAccountID: ledgerAccountID,
+		}
+		if item.Discount != 0 {
+			invoice.Details[i].Description += fmt.Sprintf(" (discount %s)", item.Discount)
+		}
+		if rule != nil {
+			invoice.Details[i].ProjectID = rule.ProjectID
 		}
 	}
 
-	return invoice
-} 
\ No newline at end of file
+	return invoice, nil
+}
+
+// detailPrice gives the quantity and unit price of a line as Moneybird takes
+// them. Purchase invoice details have no discount and prices in cents, so
+// lines with a discount, a unit price below the cent or a unit price that
+// does not multiply to the exact line total are booked as a single unit at
+// the line total.
+func detailPrice(item extraction.InvoiceItem) (string, money.Amount) {
+	price := money.FromFloat(item.UnitPrice)
+	if item.Quantity > 0 && price != 0 && item.Discount == 0 &&
+		math.Abs(price.Float64()-item.UnitPrice) < 1e-9 &&
+		money.FromFloat(item.Quantity*item.UnitPrice) == item.Amount {
+		return strconv.FormatFloat(item.Quantity, 'f', -1, 64), price
+	}
+	return "1", item.Amount
+}
@@ -2,32 +2,56 @@ package processor
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/janyksteenbeek/birdgpt/config"
+	"github.com/janyksteenbeek/birdgpt/internal/extraction"
+	"github.com/janyksteenbeek/birdgpt/internal/fx"
 	"github.com/janyksteenbeek/birdgpt/internal/gmail"
+	"github.com/janyksteenbeek/birdgpt/internal/money"
 	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
-	"github.com/janyksteenbeek/birdgpt/internal/openai"
+	"github.com/janyksteenbeek/birdgpt/internal/rules"
+	"github.com/janyksteenbeek/birdgpt/internal/store"
+	"github.com/janyksteenbeek/birdgpt/internal/tax"
 )
 
 type Processor struct {
+	store              *store.Store
+	rules              *rules.Set
+	rates              fx.Provider
 	emailProcessor     *EmailProcessor
+	filter             *Filter
 	invoiceProcessor   *InvoiceProcessor
 	moneybirdProcessor *MoneybirdProcessor
-	cfg               *config.Config
+	labelProcessor     *LabelProcessor
+	cfg                *config.Config
 }
 
-func New(cfg *config.Config, gmailClient *gmail.Client, moneybirdClient *moneybird.Client, openaiClient *openai.Client) *Processor {
+func New(cfg *config.Config, st *store.Store, vendorRules *rules.Set, rates fx.Provider, classifier *tax.Classifier, gmailClient *gmail.Client, moneybirdClient *moneybird.Client, extractor extraction.Extractor) *Processor {
 	return &Processor{
-		emailProcessor:     NewEmailProcessor(cfg, gmailClient),
-		invoiceProcessor:   NewInvoiceProcessor(openaiClient),
-		moneybirdProcessor: NewMoneybirdProcessor(cfg, moneybirdClient),
-		cfg:               cfg,
+		store:              st,
+		rules:              vendorRules,
+		rates:              rates,
+		emailProcessor:     NewEmailProcessor(cfg, gmailClient, st),
+		filter:             NewFilter(cfg),
+		invoiceProcessor:   NewInvoiceProcessor(cfg, extractor),
+		moneybirdProcessor: NewMoneybirdProcessor(cfg, moneybirdClient, classifier),
+		labelProcessor:     NewLabelProcessor(cfg, gmailClient),
+		cfg:                cfg,
 	}
 }
 
 func (p *Processor) Run(ctx context.Context) error {
+	if err := p.recoverInterrupted(); err != nil {
+		return err
+	}
+
 	if err := p.processNewEmails(ctx); err != nil {
 		log.Printf("Error processing emails: %v", err)
 	}
@@ -54,23 +78,436 @@ func (p *Processor) processNewEmails(ctx context.Context) error {
 	}
 
 	for _, email := range emails {
-		invoiceData, err := p.invoiceProcessor.ProcessEmail(ctx, email)
+		outcome, record, err := p.processEmail(ctx, email)
+		if err != nil {
+			// Without a ledger entry the outcome would be lost, stop and retry next cycle.
+			return err
+		}
+
+		if err := p.labelProcessor.Apply(ctx, email, outcome, record.Tags); err != nil {
+			log.Printf("Failed to label email %s: %v", email.Subject, err)
+		}
+	}
+
+	return nil
+}
+
+// processEmail handles a single email and records the result in the ledger.
+// The returned error is only set when the ledger itself could not be updated.
+func (p *Processor) processEmail(ctx context.Context, email gmail.Email) (Outcome, *store.Record, error) {
+	record, err := p.store.Get(email.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		record = &store.Record{MessageID: email.ID}
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	record.Subject = email.Subject
+	record.From = email.From
+	record.Status = store.StatusProcessing
+	record.Attempts++
+	if err := p.store.Put(record); err != nil {
+		return "", nil, err
+	}
+
+	outcome, err := p.bookEmail(ctx, email, record)
+	switch {
+	case err == nil:
+		record.Status = outcome.status()
+		record.LastError = ""
+		if outcome == OutcomeBooked {
+			record.Reason = ""
+			record.ContactOverride = ""
+			record.Approved = false
+		}
+		record.NextAttemptAt = time.Time{}
+	case !isTransient(err) || record.Attempts >= p.cfg.App.MaxAttempts:
+		log.Printf("Giving up on email %s after %d attempt(s): %v", email.Subject, record.Attempts, err)
+		record.Status = store.StatusDead
+		record.LastError = err.Error()
+	default:
+		record.Status = store.StatusFailed
+		record.LastError = err.Error()
+		record.NextAttemptAt = time.Now().Add(p.retryDelay(record.Attempts))
+		log.Printf("Retrying email %s at %v", email.Subject, record.NextAttemptAt.Format(time.RFC3339))
+	}
+
+	if err := p.store.Put(record); err != nil {
+		return "", nil, err
+	}
+
+	return outcome, record, nil
+}
+
+// recoverInterrupted requeues the emails a previous run was processing when
+// it stopped.
+func (p *Processor) recoverInterrupted() error {
+	interrupted, err := p.store.List(store.WithStatus(store.StatusProcessing))
+	if err != nil {
+		return err
+	}
+
+	for i := range interrupted {
+		record := &interrupted[i]
+		record.Recover()
+		log.Printf("Email %s was interrupted while processing, now %s", record.Subject, record.Status)
+		if err := p.store.Put(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryDelay doubles the configured delay for every attempt, up to max_retry_delay.
+func (p *Processor) retryDelay(attempts int) time.Duration {
+	delay := p.cfg.App.RetryDelay
+	for i := 1; i < attempts && delay < p.cfg.App.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.cfg.App.MaxRetryDelay)
+}
+
+func (p *Processor) bookEmail(ctx context.Context, email gmail.Email, record *store.Record) (Outcome, error) {
+	if rule := p.filter.Check(email); rule != "" {
+		log.Printf("Email %s rejected by filter rule %s, skipping", email.Subject, rule)
+		record.Reason = "filter: " + rule
+		return OutcomeSkipped, nil
+	}
+
+	record.AttachmentHashes = attachmentHashes(email)
+	original, err := p.findDuplicateEmail(record)
+	if err != nil {
+		return OutcomeFailed, err
+	}
+	if original != nil {
+		log.Printf("Email %s carries the same attachments as already booked email %s, skipping", email.Subject, original.MessageID)
+		record.DuplicateOf = original.MessageID
+		record.Reason = "duplicate attachment"
+		return OutcomeSkipped, nil
+	}
+
+	// Rules on the sender apply before the model is asked, so emails from
+	// suppliers that are always reviewed cost no tokens.
+	if rule := p.rules.FindSender(email.From); rule != nil && rule.Review && !record.Approved {
+		log.Printf("Email %s matches vendor rule %s, flagging for review before extraction", email.Subject, rule.Name)
+		record.Reason = fmt.Sprintf("vendor rule %s requires review", rule.Name)
+		addTags(record, rule.Tags)
+		return OutcomeReview, nil
+	}
+
+	invoices, err := p.extractInvoices(ctx, email, record)
+	if err != nil {
+		log.Printf("Failed to process email %s: %v", email.Subject, err)
+		return OutcomeFailed, err
+	}
+
+	if len(invoices) == 0 {
+		record.Reason = "not an invoice"
+		return OutcomeSkipped, nil
+	}
+
+	// Every document is booked on its own. Documents of earlier attempts are
+	// kept, and those that are done are not booked again.
+	documents := slices.Clone(record.Documents)
+	claimed := make(map[int]bool)
+	outcomes := make([]Outcome, len(invoices))
+	var errs []error
+	for i, invoiceData := range invoices {
+		files := sourceFiles(email, invoiceData, len(invoices) == 1)
+		j := previousDocument(documents, claimed, email, invoiceData)
+		if j < 0 {
+			documents = append(documents, newDocument(email, invoiceData))
+			j = len(documents) - 1
+		}
+		claimed[j] = true
+
+		doc := &documents[j]
+		if doc.Done() {
+			outcomes[i] = outcomeOf(doc.Status)
+			continue
+		}
+
+		// Mark the document before booking, so a crash while the purchase
+		// invoice is being created is not followed by booking it twice.
+		doc.Status = store.StatusProcessing
+		record.Documents = documents
+		if err := p.store.Put(record); err != nil {
+			return OutcomeFailed, err
+		}
+
+		outcomes[i], err = p.bookDocument(ctx, email, record, doc, invoiceData, files)
+		doc.Status = outcomes[i].status()
+		doc.LastError = ""
 		if err != nil {
-			log.Printf("Failed to process email %s: %v", email.Subject, err)
+			log.Printf("Failed to book invoice %s from email %s: %v", invoiceData.InvoiceNumber, email.Subject, err)
+			doc.LastError = err.Error()
+			errs = append(errs, err)
+		}
+	}
+	record.Documents = documents
+
+	// Name the document with each reason, as requeueing a single document
+	// takes its key.
+	var reasons []string
+	for i, doc := range documents {
+		if doc.Reason == "" {
 			continue
 		}
+		if reason := record.DocumentKey(i) + ": " + doc.Reason; !slices.Contains(reasons, reason) {
+			reasons = append(reasons, reason)
+		}
+	}
+	record.Reason = strings.Join(reasons, "; ")
 
-		if invoiceData != nil {
-			if err := p.moneybirdProcessor.ProcessInvoice(ctx, invoiceData); err != nil {
-				log.Printf("Failed to process invoice for email %s: %v", email.Subject, err)
-				continue
-			}
+	outcome := combineOutcomes(outcomes)
+	if outcome == OutcomeFailed {
+		return outcome, documentsError(errs)
+	}
+	return outcome, nil
+}
+
+// extractInvoices asks the model for the invoices in the email. The answer is
+// stored with the record right away and reused on retries, so an attempt
+// never sees different documents than the one before it.
+func (p *Processor) extractInvoices(ctx context.Context, email gmail.Email, record *store.Record) ([]*extraction.InvoiceData, error) {
+	if record.Extraction != nil {
+		var invoices []*extraction.InvoiceData
+		if err := json.Unmarshal(record.Extraction, &invoices); err == nil {
+			return invoices, nil
 		}
+		log.Printf("Failed to decode stored extraction of email %s, extracting again", email.Subject)
 	}
 
-	if err := p.emailProcessor.UpdateLastProcessed(); err != nil {
-		log.Printf("Failed to update last processed time: %v", err)
+	invoices, err := p.invoiceProcessor.ProcessEmail(ctx, email)
+	if err != nil {
+		return nil, err
 	}
 
+	if record.Extraction, err = json.Marshal(invoices); err != nil {
+		log.Printf("Failed to encode extraction of email %s: %v", email.Subject, err)
+		record.Extraction = nil
+	} else if err := p.store.Put(record); err != nil {
+		return nil, err
+	}
+
+	return invoices, nil
+}
+
+// bookDocument books a single invoice of the email in Moneybird.
+func (p *Processor) bookDocument(ctx context.Context, email gmail.Email, record *store.Record, doc *store.Document, invoiceData *extraction.InvoiceData, files []gmail.Attachment) (Outcome, error) {
+	if doc.AttachmentHash != "" {
+		original, booked, err := findBookedAttachment(p.store, record.MessageID, doc.AttachmentHash)
+		if err != nil {
+			return OutcomeFailed, err
+		}
+		if original != nil {
+			log.Printf("Attachment %s was already booked from email %s, skipping", doc.Attachment, original.MessageID)
+			doc.DuplicateOf = original.MessageID
+			doc.PurchaseInvoiceID = booked.PurchaseInvoiceID
+			doc.Reason = "duplicate attachment"
+			return OutcomeSkipped, nil
+		}
+	}
+
+	if err := p.invoiceProcessor.Validate(invoiceData); err != nil {
+		return OutcomeFailed, err
+	}
+	p.convertCurrency(ctx, doc, invoiceData)
+
+	// Rules are matched on the extracted identifiers too, so a rule keyed by
+	// VAT number applies whichever address the invoice was sent from.
+	rule := p.rules.Find(email.From, invoiceData.VatNumber, invoiceData.CompanyName)
+	if rule != nil {
+		log.Printf("Applying vendor rule %s to invoice %s", rule.Name, invoiceData.InvoiceNumber)
+		p.invoiceProcessor.ApplyRule(invoiceData, rule)
+		doc.Rule = rule.Name
+		addTags(record, rule.Tags)
+	}
+
+	var err error
+	if doc.Invoice, err = json.Marshal(invoiceData); err != nil {
+		log.Printf("Failed to encode invoice data for email %s: %v", email.Subject, err)
+	}
+
+	booking, err := p.moneybirdProcessor.ProcessInvoice(ctx, invoiceData, BookingOptions{
+		Sender:     email.From,
+		ContactID:  record.ContactOverride,
+		Rule:       rule,
+		Approved:   record.Approved,
+		ApproveVAT: doc.ApproveVAT,
+	})
+	if booking != nil {
+		record.ContactChanges = append(record.ContactChanges, booking.ContactChanges...)
+	}
+	if err != nil {
+		return OutcomeFailed, err
+	}
+
+	if booking.Review {
+		doc.ContactID = booking.ContactID
+		doc.Reason = booking.ReviewReason
+		return OutcomeReview, nil
+	}
+	doc.Reason = ""
+
+	// An earlier attempt created the invoice but failed before attaching.
+	resumed := booking.Existing && doc.PurchaseInvoiceID == booking.PurchaseInvoiceID
+	if doc.PurchaseInvoiceID != booking.PurchaseInvoiceID {
+		doc.Uploaded = nil
+	}
+
+	doc.ContactID = booking.ContactID
+	doc.PurchaseInvoiceID = booking.PurchaseInvoiceID
+	if booking.CreditedInvoiceID != "" {
+		doc.CreditedInvoiceID = booking.CreditedInvoiceID
+	}
+
+	// The attachments were not seen before, so a document matching an
+	// existing invoice brings a new copy of something already booked: link it.
+	if booking.Existing && !resumed && len(files) == 0 {
+		doc.Reason = "duplicate purchase invoice"
+		return OutcomeSkipped, nil
+	}
+
+	// A failed upload is retried; the invoice is then found as existing and
+	// only the files that were not uploaded yet are added.
+	doc.Uploaded, err = p.moneybirdProcessor.AttachSourceDocuments(ctx, booking.PurchaseInvoiceID, email, files, doc.Uploaded)
+	if err != nil {
+		return OutcomeFailed, fmt.Errorf("failed to attach source document: %w", err)
+	}
+
+	if booking.Existing && !resumed {
+		log.Printf("Linked invoice %s from email %s to existing purchase invoice %s", invoiceData.InvoiceNumber, email.Subject, booking.PurchaseInvoiceID)
+		doc.Linked = true
+	}
+
+	return OutcomeBooked, nil
+}
+
+// convertCurrency records the total of a foreign currency invoice in the
+// administration currency. Moneybird converts with its own rates when
+// booking, so a missing rate is logged and otherwise ignored.
+func (p *Processor) convertCurrency(ctx context.Context, doc *store.Document, invoiceData *extraction.InvoiceData) {
+	doc.Currency = invoiceData.Currency
+	target := p.cfg.Moneybird.Currency
+	if p.rates == nil || invoiceData.Currency == target {
+		return
+	}
+
+	date, err := time.Parse("2006-01-02", invoiceData.InvoiceDate)
+	if err != nil {
+		date = time.Now()
+	}
+
+	total := money.Money{Amount: invoiceData.TotalAmount, Currency: invoiceData.Currency}
+	converted, rate, err := fx.Convert(ctx, p.rates, total, target, date)
+	if err != nil {
+		log.Printf("No exchange rate for invoice %s in %s: %v", invoiceData.InvoiceNumber, invoiceData.Currency, err)
+		return
+	}
+
+	log.Printf("Invoice %s: %s is %s at %.6g", invoiceData.InvoiceNumber, total, converted, rate)
+	doc.ExchangeRate = rate
+	doc.ConvertedTotal = converted.Amount
+}
+
+// findDuplicateEmail returns another email that already booked every
+// attachment of the record, so the model does not have to be asked again.
+func (p *Processor) findDuplicateEmail(record *store.Record) (*store.Record, error) {
+	var first *store.Record
+	for _, hash := range record.AttachmentHashes {
+		original, _, err := findBookedAttachment(p.store, record.MessageID, hash)
+		if err != nil || original == nil {
+			return nil, err
+		}
+		if first == nil {
+			first = original
+		}
+	}
+	return first, nil
+}
+
+// sourceFile returns the attachment the invoice was found in, or nil for the
+// email body.
+func sourceFile(email gmail.Email, invoiceData *extraction.InvoiceData) *gmail.Attachment {
+	files := email.Files()
+	if i := invoiceData.SourceAttachment; i >= 1 && i <= len(files) {
+		return &files[i-1]
+	}
+	if len(files) == 1 && invoiceData.SourceAttachment != 0 {
+		return &files[0]
+	}
+	return nil
+}
+
+// sourceFiles returns the files to keep with an invoice. The only invoice of
+// an email keeps all its files, such as terms sent along with it.
+func sourceFiles(email gmail.Email, invoiceData *extraction.InvoiceData, only bool) []gmail.Attachment {
+	if only {
+		return email.Files()
+	}
+	if file := sourceFile(email, invoiceData); file != nil {
+		return []gmail.Attachment{*file}
+	}
 	return nil
 }
+
+// newDocument starts the ledger entry for an invoice found in the email.
+func newDocument(email gmail.Email, invoiceData *extraction.InvoiceData) store.Document {
+	doc := store.Document{Reference: documentReference(invoiceData)}
+	if file := sourceFile(email, invoiceData); file != nil {
+		doc.Attachment = file.Filename
+		doc.AttachmentHash = attachmentHash(*file)
+	}
+	return doc
+}
+
+// previousDocument returns the index of the document an earlier attempt
+// recorded for the invoice, matched on its attachment and reference, or -1.
+// Documents already claimed by another invoice of this attempt are skipped.
+func previousDocument(documents []store.Document, claimed map[int]bool, email gmail.Email, invoiceData *extraction.InvoiceData) int {
+	doc := newDocument(email, invoiceData)
+	for i, previous := range documents {
+		if claimed[i] {
+			continue
+		}
+		if previous.AttachmentHash == doc.AttachmentHash && normalizeReference(previous.Reference) == normalizeReference(doc.Reference) {
+			return i
+		}
+	}
+	return -1
+}
+
+// addTags records the tags of a vendor rule on the email.
+func addTags(record *store.Record, tags []string) {
+	for _, tag := range tags {
+		if !slices.Contains(record.Tags, tag) {
+			record.Tags = append(record.Tags, tag)
+		}
+	}
+}
+
+// combineOutcomes summarises the documents of an email: any failure makes the
+// email failed so it is retried, then review, then booked.
+func combineOutcomes(outcomes []Outcome) Outcome {
+	for _, o := range []Outcome{OutcomeFailed, OutcomeReview, OutcomeBooked} {
+		if slices.Contains(outcomes, o) {
+			return o
+		}
+	}
+	return OutcomeSkipped
+}
+
+// documentsError picks the error that decides whether the email is retried:
+// a transient one when any document can still succeed.
+func documentsError(errs []error) error {
+	for _, err := range errs {
+		if isTransient(err) {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return errors.New("booking failed")
+}
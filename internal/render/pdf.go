@@ -0,0 +1,145 @@
+// Package render turns plain text into a simple PDF document, used to keep a
+// copy of emails that carry the invoice in their body instead of as a file.
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	pageWidth  = 595 // A4 in points
+	pageHeight = 842
+	margin     = 50
+	fontSize   = 9
+	lineHeight = 12
+	// Courier glyphs are 600/1000 em wide.
+	charsPerLine = (pageWidth - 2*margin) * 1000 / (fontSize * 600)
+	linesPerPage = (pageHeight - 2*margin) / lineHeight
+)
+
+// TextPDF renders text in a monospaced font on as many A4 pages as needed,
+// wrapping long lines.
+func TextPDF(text string) []byte {
+	lines := wrap(text)
+	var pages [][]string
+	for len(lines) > linesPerPage {
+		pages = append(pages, lines[:linesPerPage])
+		lines = lines[linesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	w := &pdfWriter{}
+	w.buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1 and 2 are the catalog and page tree, 3 is the font, after
+	// that every page has a page object followed by its content stream.
+	pageIDs := make([]int, len(pages))
+	for i := range pages {
+		pageIDs[i] = 4 + 2*i
+	}
+
+	w.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(pageIDs))
+	for i, id := range pageIDs {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	w.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	w.object(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range pages {
+		w.object(pageIDs[i], fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, pageIDs[i]+1))
+
+		content := pageContent(page)
+		w.object(pageIDs[i]+1, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	w.trailer()
+	return w.buf.Bytes()
+}
+
+func pageContent(lines []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "BT /F1 %d Tf %d TL %d %d Td", fontSize, lineHeight, margin, pageHeight-margin)
+	for _, line := range lines {
+		fmt.Fprintf(&b, " (%s) '", escape(line))
+	}
+	b.WriteString(" ET")
+	return b.String()
+}
+
+func wrap(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		runes := []rune(strings.TrimRight(line, " "))
+		for len(runes) > charsPerLine {
+			cut := charsPerLine
+			// Prefer breaking at the last space on the line.
+			for i := charsPerLine; i > charsPerLine/2; i-- {
+				if runes[i] == ' ' {
+					cut = i
+					break
+				}
+			}
+			lines = append(lines, string(runes[:cut]))
+			runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+var winAnsi = encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder())
+
+func escape(line string) string {
+	encoded, err := winAnsi.String(line)
+	if err != nil {
+		encoded = line
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(encoded); i++ {
+		switch c := encoded[i]; c {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			if c < 0x20 {
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (w *pdfWriter) object(id int, body string) {
+	for len(w.offsets) < id {
+		w.offsets = append(w.offsets, 0)
+	}
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *pdfWriter) trailer() {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, xref)
+}
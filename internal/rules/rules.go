@@ -0,0 +1,163 @@
+// Package rules loads per-supplier overrides from a YAML file next to
+// config.yaml. Rules are matched on the sender address or domain, the VAT
+// number or the company name, so they apply deterministically whatever the
+// model made of the invoice.
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/janyksteenbeek/birdgpt/internal/tax"
+	"gopkg.in/yaml.v3"
+)
+
+type Rule struct {
+	Name  string `yaml:"name"`
+	Match Match  `yaml:"match"`
+
+	// ContactID pins the Moneybird contact.
+	ContactID string `yaml:"contact_id"`
+	// LedgerAccount is a ledger account ID, code or name for every line.
+	LedgerAccount string `yaml:"ledger_account"`
+	// TaxRate replaces the extracted tax rate percentage of every line.
+	TaxRate *float64 `yaml:"tax_rate"`
+	// VAT pins the VAT treatment of every line, such as reverse_charge_eu.
+	VAT string `yaml:"vat"`
+	// ProjectID books every line on a Moneybird project.
+	ProjectID string `yaml:"project_id"`
+	// Tags are recorded in the processing ledger and added as Gmail labels.
+	Tags []string `yaml:"tags"`
+	// CollapseLines books a single line per tax rate instead of every item.
+	CollapseLines bool `yaml:"collapse_lines"`
+	// Review flags every invoice of this supplier for review instead of
+	// booking it automatically.
+	Review bool `yaml:"review"`
+}
+
+// Match lists the identifiers a rule applies to. Any one of them matching is
+// enough.
+type Match struct {
+	Sender      string `yaml:"sender"`
+	Domain      string `yaml:"domain"`
+	VatNumber   string `yaml:"vat_number"`
+	CompanyName string `yaml:"company_name"`
+}
+
+type Set struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Load reads the rules file. A missing file yields an empty set.
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Set{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+
+	var set Set
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing rules file: %w", err)
+	}
+
+	for i, rule := range set.Rules {
+		if rule.Match == (Match{}) {
+			return nil, fmt.Errorf("rule %d (%s) has no match criteria", i+1, rule.Name)
+		}
+		if rule.Name == "" {
+			set.Rules[i].Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.VAT != "" {
+			treatment, err := tax.ParseTreatment(rule.VAT)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d (%s): %w", i+1, set.Rules[i].Name, err)
+			}
+			set.Rules[i].VAT = string(treatment)
+		}
+	}
+
+	return &set, nil
+}
+
+// Match strengths, strongest first.
+const (
+	byVatNumber = iota
+	bySender
+	byDomain
+	byCompanyName
+	noMatch
+)
+
+// Find returns the rule that matches the strongest identifier, preferring the
+// earliest rule in the file on a tie. Empty arguments are ignored.
+func (s *Set) Find(sender, vatNumber, companyName string) *Rule {
+	if s == nil {
+		return nil
+	}
+
+	address := SenderAddress(sender)
+	domain := ""
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		domain = address[i+1:]
+	}
+
+	var best *Rule
+	bestStrength := noMatch
+	for i := range s.Rules {
+		rule := &s.Rules[i]
+		strength := noMatch
+		switch {
+		case vatNumber != "" && rule.Match.VatNumber != "" && normalize(rule.Match.VatNumber) == normalize(vatNumber):
+			strength = byVatNumber
+		case address != "" && rule.Match.Sender != "" && strings.EqualFold(rule.Match.Sender, address):
+			strength = bySender
+		case domain != "" && rule.Match.Domain != "" && matchesDomain(domain, rule.Match.Domain):
+			strength = byDomain
+		case companyName != "" && rule.Match.CompanyName != "" && normalize(rule.Match.CompanyName) == normalize(companyName):
+			strength = byCompanyName
+		}
+
+		if strength < bestStrength {
+			best, bestStrength = rule, strength
+		}
+	}
+
+	return best
+}
+
+// FindSender returns the rule matching the sender address or domain, which
+// is all that is known before the invoice is extracted.
+func (s *Set) FindSender(sender string) *Rule {
+	return s.Find(sender, "", "")
+}
+
+// SenderAddress returns the lowercased address of a From header, such as
+// "billing@example.com" for "Example <Billing@Example.com>".
+func SenderAddress(sender string) string {
+	if parsed, err := mail.ParseAddress(sender); err == nil {
+		sender = parsed.Address
+	}
+	return strings.ToLower(strings.TrimSpace(sender))
+}
+
+// matchesDomain also accepts subdomains, so example.com matches mail.example.com.
+func matchesDomain(domain, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimPrefix(pattern, "@"))
+	return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+}
+
+func normalize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,192 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/janyksteenbeek/birdgpt/internal/money"
+)
+
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusProcessing Status = "processing"
+	StatusBooked     Status = "booked"
+	StatusSkipped    Status = "skipped"
+	StatusFailed     Status = "failed"
+	// StatusDead is for emails that failed permanently or ran out of
+	// attempts. They are only retried after a manual requeue.
+	StatusDead Status = "dead"
+	// StatusReview is for emails that need a human decision, such as an
+	// uncertain contact match. They are only retried after a manual requeue.
+	StatusReview Status = "review"
+)
+
+// Record is the ledger entry for a single Gmail message.
+type Record struct {
+	MessageID string `json:"message_id"`
+	Subject   string `json:"subject,omitempty"`
+	From      string `json:"from,omitempty"`
+	Status    Status `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+
+	// NextAttemptAt is when a failed record becomes due again.
+	NextAttemptAt time.Time `json:"next_attempt_at,omitempty"`
+
+	// ContactOverride pins the Moneybird contact, set when requeueing a
+	// record that was flagged for review.
+	ContactOverride string `json:"contact_override,omitempty"`
+	// Approved books the email even when a vendor rule asks for review, set
+	// when requeueing a record that was flagged for review.
+	Approved bool `json:"approved,omitempty"`
+
+	// Extraction is the model's answer for the email, reused on retries so
+	// the documents stay the same between attempts.
+	Extraction json.RawMessage `json:"extraction,omitempty"`
+	// Documents are the invoices found in the email, each booked on its own.
+	Documents []Document `json:"documents,omitempty"`
+	// Tags are the tags set by the vendor rules of the documents.
+	Tags []string `json:"tags,omitempty"`
+
+	// ContactChanges is the audit trail of contact fields updated with
+	// details from this email.
+	ContactChanges []ContactChange `json:"contact_changes,omitempty"`
+
+	// AttachmentHashes are the SHA-256 hashes of the email's attachments,
+	// used to recognise the same document arriving twice.
+	AttachmentHashes []string `json:"attachment_hashes,omitempty"`
+	// DuplicateOf is the message ID of the email that already booked all
+	// of the email's attachments.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Reason explains why an email was skipped or needs review.
+	Reason string `json:"reason,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Document is the ledger entry for a single invoice in an email.
+type Document struct {
+	// Reference is the Moneybird reference of the invoice and Attachment the
+	// name of the file it was found in, empty for the email body.
+	Reference      string `json:"reference,omitempty"`
+	Attachment     string `json:"attachment,omitempty"`
+	AttachmentHash string `json:"attachment_hash,omitempty"`
+
+	Status    Status `json:"status"`
+	LastError string `json:"last_error,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+
+	// Invoice holds the extracted invoice data as returned by the model.
+	Invoice json.RawMessage `json:"invoice,omitempty"`
+	// Rule is the name of the vendor rule applied to the document.
+	Rule string `json:"rule,omitempty"`
+	// ApproveVAT books lines with uncertain VAT at their extracted rate, set
+	// when requeueing a document that was flagged for review.
+	ApproveVAT bool `json:"approve_vat,omitempty"`
+
+	// ExchangeRate and ConvertedTotal record the total of an invoice in a
+	// foreign currency in the administration currency.
+	Currency       string       `json:"currency,omitempty"`
+	ExchangeRate   float64      `json:"exchange_rate,omitempty"`
+	ConvertedTotal money.Amount `json:"converted_total,omitempty"`
+
+	ContactID         string `json:"contact_id,omitempty"`
+	PurchaseInvoiceID string `json:"purchase_invoice_id,omitempty"`
+	// DuplicateOf is the message ID of the email that already booked the
+	// same attachment.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// Linked is set when the document was matched to an existing purchase
+	// invoice instead of creating a new one.
+	Linked bool `json:"linked,omitempty"`
+	// CreditedInvoiceID is the purchase invoice a credit note was linked to.
+	CreditedInvoiceID string `json:"credited_invoice_id,omitempty"`
+	// Uploaded holds the hashes of the files attached to the purchase
+	// invoice, so a retry does not upload them again.
+	Uploaded []string `json:"uploaded,omitempty"`
+}
+
+// Done reports whether the document needs no further processing.
+func (d *Document) Done() bool {
+	return d.Status == StatusBooked || d.Status == StatusSkipped
+}
+
+// ContactChange records a single contact field update.
+type ContactChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Due reports whether the record should be processed at now. Records still
+// processing were interrupted and go through Recover first.
+func (r *Record) Due(now time.Time) bool {
+	switch r.Status {
+	case StatusPending:
+		return true
+	case StatusFailed:
+		return !now.Before(r.NextAttemptAt)
+	default:
+		return false
+	}
+}
+
+// Requeue resets the record so it is processed again on the next cycle.
+func (r *Record) Requeue() {
+	r.Status = StatusPending
+	r.Attempts = 0
+	r.NextAttemptAt = time.Time{}
+}
+
+// Recover puts a record that was interrupted while processing back in the
+// queue. When a document was being booked it may already exist in Moneybird,
+// so the record goes to review instead of being booked again.
+func (r *Record) Recover() {
+	for i, doc := range r.Documents {
+		if doc.Status == StatusProcessing {
+			r.Status = StatusReview
+			r.Reason = fmt.Sprintf("interrupted while booking %s, check Moneybird before requeueing", r.DocumentKey(i))
+			return
+		}
+	}
+	r.Status = StatusPending
+}
+
+// DocumentKey names the document at index i: its reference, or its position
+// such as "#2" for a document without one.
+func (r *Record) DocumentKey(i int) string {
+	if r.Documents[i].Reference != "" {
+		return r.Documents[i].Reference
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// ApproveVAT approves the extracted VAT rates of the unbooked documents with
+// key, as given by DocumentKey.
+func (r *Record) ApproveVAT(key string) error {
+	var found bool
+	for i := range r.Documents {
+		if r.DocumentKey(i) == key && !r.Documents[i].Done() {
+			r.Documents[i].ApproveVAT = true
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("no unbooked document %q", key)
+	}
+	return nil
+}
+
+// BookedDocument returns the booked document that was found in the
+// attachment with hash, if any.
+func (r *Record) BookedDocument(hash string) *Document {
+	for i := range r.Documents {
+		if r.Documents[i].Status == StatusBooked && r.Documents[i].AttachmentHash == hash {
+			return &r.Documents[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned when no record exists for a message ID.
+var ErrNotFound = errors.New("record not found")
+
+var (
+	messagesBucket = []byte("messages")
+	metaBucket     = []byte("meta")
+	ocrBucket      = []byte("ocr")
+
+	historyIDKey = []byte("history_id")
+	lastSyncKey  = []byte("last_sync")
+)
+
+// Store is the processing ledger: one record per Gmail message, kept in an
+// embedded bbolt database.
+type Store struct {
+	db *bolt.DB
+}
+
+func Open(path string) (*Store, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("creating state directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening state file: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{messagesBucket, metaBucket, ocrBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing state file: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Enqueue adds a pending record for every message ID that is not in the
+// ledger yet and returns how many were added.
+func (s *Store) Enqueue(messageIDs []string) (int, error) {
+	added := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(messagesBucket)
+		now := time.Now()
+		for _, id := range messageIDs {
+			if bucket.Get([]byte(id)) != nil {
+				continue
+			}
+			record := &Record{MessageID: id, Status: StatusPending, CreatedAt: now, UpdatedAt: now}
+			if err := putRecord(bucket, record); err != nil {
+				return err
+			}
+			added++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("enqueueing messages: %w", err)
+	}
+
+	return added, nil
+}
+
+func (s *Store) Get(messageID string) (*Record, error) {
+	var record *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(messagesBucket).Get([]byte(messageID))
+		if data == nil {
+			return ErrNotFound
+		}
+		record = &Record{}
+		return json.Unmarshal(data, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Put stores the record, stamping UpdatedAt.
+func (s *Store) Put(record *Record) error {
+	record.UpdatedAt = time.Now()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = record.UpdatedAt
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putRecord(tx.Bucket(messagesBucket), record)
+	})
+	if err != nil {
+		return fmt.Errorf("storing record %s: %w", record.MessageID, err)
+	}
+
+	return nil
+}
+
+// List returns the records matching filter, oldest first. A nil filter
+// matches every record.
+func (s *Store) List(filter func(*Record) bool) ([]Record, error) {
+	var records []Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(messagesBucket).ForEach(func(_, data []byte) error {
+			var record Record
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if filter == nil || filter(&record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing records: %w", err)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records, nil
+}
+
+// WithStatus returns a List filter for records in any of the given states.
+func WithStatus(statuses ...Status) func(*Record) bool {
+	return func(r *Record) bool {
+		for _, status := range statuses {
+			if r.Status == status {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SyncState returns the Gmail history ID and the time of the last completed sync.
+func (s *Store) SyncState() (uint64, time.Time, error) {
+	var historyID uint64
+	var lastSync time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if data := meta.Get(historyIDKey); len(data) == 8 {
+			historyID = binary.BigEndian.Uint64(data)
+		}
+		if data := meta.Get(lastSyncKey); data != nil {
+			return lastSync.UnmarshalText(data)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("reading sync state: %w", err)
+	}
+
+	return historyID, lastSync, nil
+}
+
+func (s *Store) SetSyncState(historyID uint64, lastSync time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if err := meta.Put(historyIDKey, binary.BigEndian.AppendUint64(nil, historyID)); err != nil {
+			return err
+		}
+		data, err := lastSync.MarshalText()
+		if err != nil {
+			return err
+		}
+		return meta.Put(lastSyncKey, data)
+	})
+	if err != nil {
+		return fmt.Errorf("storing sync state: %w", err)
+	}
+
+	return nil
+}
+
+// CachedText returns the OCR text stored under key, usually the hash of an
+// attachment.
+func (s *Store) CachedText(key string) (string, bool, error) {
+	var text string
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// An empty value is a hit too: OCR found no text.
+		if data := tx.Bucket(ocrBucket).Get([]byte(key)); data != nil {
+			text, found = string(data), true
+		}
+		return nil
+	})
+	return text, found, err
+}
+
+// CacheText stores the OCR text for key.
+func (s *Store) CacheText(key, text string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ocrBucket).Put([]byte(key), []byte(text))
+	})
+}
+
+func putRecord(bucket *bolt.Bucket, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(record.MessageID), data)
+}
@@ -0,0 +1,118 @@
+package tax
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+)
+
+// nameOrder is the order in which tax rate names are matched against the
+// patterns; a rate matching several belongs to the first.
+var nameOrder = []Treatment{ReverseChargeEU, Import, ReverseChargeDomestic, Exempt, Zero}
+
+// Rates maps treatments to the purchase tax rates of the administration.
+// Special treatments are found by name; rates whose name matches none of the
+// patterns are regular rates, found by percentage. Nothing is matched by
+// approximation.
+type Rates struct {
+	named   map[Treatment][]moneybird.TaxRate
+	regular []moneybird.TaxRate
+}
+
+// NewRates sorts the tax rates by treatment. Patterns are case-insensitive
+// regular expressions keyed by treatment name.
+func NewRates(rates []moneybird.TaxRate, patterns map[string]string) (*Rates, error) {
+	compiled := make(map[Treatment]*regexp.Regexp)
+	for name, pattern := range patterns {
+		t, err := ParseTreatment(name)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(nameOrder, t) {
+			return nil, fmt.Errorf("%s tax rates are found by percentage, not by name", t)
+		}
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for %s tax rates: %w", t, err)
+		}
+		compiled[t] = re
+	}
+
+	r := &Rates{named: make(map[Treatment][]moneybird.TaxRate)}
+rates:
+	for _, rate := range rates {
+		if rate.TaxRateType != "" && rate.TaxRateType != "purchase_invoice" {
+			continue
+		}
+		for _, t := range nameOrder {
+			if re := compiled[t]; re != nil && re.MatchString(rate.Name) {
+				r.named[t] = append(r.named[t], rate)
+				continue rates
+			}
+		}
+		r.regular = append(r.regular, rate)
+	}
+
+	return r, nil
+}
+
+// ID returns the tax rate for a treatment, or explains why there is no
+// single one. Standard and reduced VAT, and zero-rated supplies without a
+// named rate, take the regular rate with the line's percentage.
+func (r *Rates) ID(t Treatment, percentage float64) (string, string) {
+	candidates := r.named[t]
+	switch t {
+	case Standard, Reduced:
+		candidates = r.withPercentage(percentage)
+	case Zero:
+		if len(candidates) == 0 {
+			candidates = r.withPercentage(0)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		if t == Standard || t == Reduced {
+			return "", fmt.Sprintf("no Moneybird tax rate for %s VAT at %g%%", t, percentage)
+		}
+		return "", fmt.Sprintf("no Moneybird tax rate for %s", t)
+	case 1:
+		return candidates[0].ID, ""
+	default:
+		names := make([]string, len(candidates))
+		for i, rate := range candidates {
+			names[i] = rate.Name
+		}
+		return "", fmt.Sprintf("several Moneybird tax rates for %s: %s", t, strings.Join(names, ", "))
+	}
+}
+
+// Domestic reports whether a regular rate has the percentage.
+func (r *Rates) Domestic(percentage float64) bool {
+	return len(r.withPercentage(percentage)) > 0
+}
+
+// StandardRate is the highest regular percentage; lower ones are reduced.
+func (r *Rates) StandardRate() float64 {
+	var highest float64
+	for _, rate := range r.regular {
+		highest = max(highest, rate.Percentage)
+	}
+	return highest
+}
+
+func (r *Rates) withPercentage(percentage float64) []moneybird.TaxRate {
+	var matches []moneybird.TaxRate
+	for _, rate := range r.regular {
+		if samePercentage(rate.Percentage, percentage) {
+			matches = append(matches, rate)
+		}
+	}
+	return matches
+}
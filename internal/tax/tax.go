@@ -0,0 +1,226 @@
+// Package tax decides the VAT treatment of invoice lines and the Moneybird
+// tax rate they are booked with. It only decides what it can tell with
+// confidence: anything else is reported as ambiguous, so the invoice is
+// flagged for review instead of being booked with a guessed rate.
+package tax
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+)
+
+// Treatment is the way VAT applies to a purchase.
+type Treatment string
+
+const (
+	// Standard and Reduced are domestic VAT charged by the supplier.
+	Standard Treatment = "standard"
+	Reduced  Treatment = "reduced"
+	// Zero is a supply taxed at 0%.
+	Zero Treatment = "zero"
+	// Exempt is a supply without VAT, such as insurance or a supplier in a
+	// small business scheme.
+	Exempt Treatment = "exempt"
+	// ReverseChargeEU is a purchase from a supplier in another EU country,
+	// on which the buyer accounts for the VAT.
+	ReverseChargeEU Treatment = "reverse_charge_eu"
+	// ReverseChargeDomestic is domestic VAT shifted to the buyer ("BTW
+	// verlegd"), as in construction and subcontracting.
+	ReverseChargeDomestic Treatment = "reverse_charge_domestic"
+	// Import is a service from outside the EU, on which the buyer accounts
+	// for the VAT.
+	Import Treatment = "import"
+)
+
+// Treatments lists every treatment.
+var Treatments = []Treatment{Standard, Reduced, Zero, Exempt, ReverseChargeEU, ReverseChargeDomestic, Import}
+
+// ParseTreatment checks a treatment given by name, as in a vendor rule.
+func ParseTreatment(name string) (Treatment, error) {
+	t := Treatment(strings.ToLower(strings.TrimSpace(name)))
+	if !slices.Contains(Treatments, t) {
+		return "", fmt.Errorf("unknown VAT treatment %q", name)
+	}
+	return t, nil
+}
+
+// Line types.
+const (
+	Goods    = "goods"
+	Services = "services"
+)
+
+// Invoice is what the classification is based on.
+type Invoice struct {
+	// Country and VatNumber identify the supplier. The country is derived
+	// from the VAT number when missing.
+	Country   string
+	VatNumber string
+	// Note holds the VAT remarks on the invoice, such as "BTW verlegd".
+	Note  string
+	Lines []Line
+	// Treatment, when set, pins the treatment of every line.
+	Treatment Treatment
+	// ApproveVAT books ambiguous lines at their extracted rate, when that is
+	// a regular rate of the administration.
+	ApproveVAT bool
+}
+
+type Line struct {
+	Description string
+	TaxRate     float64
+	// Type is goods or services, empty when unknown.
+	Type     string
+	Category string
+}
+
+// Decision is the outcome for a single line. When Reason is set the line is
+// ambiguous and TaxRateID is empty.
+type Decision struct {
+	Treatment Treatment
+	TaxRateID string
+	Reason    string
+}
+
+var (
+	reverseChargeText = regexp.MustCompile(`(?i)verlegd|reverse[- ]charge|autoliquidation|steuerschuldnerschaft|article 196|artikel 196`)
+	exemptText        = regexp.MustCompile(`(?i)vrijgesteld|exempt|kleineondernemersregeling|\bKOR\b|artikel 11\b|article 13[25]\b`)
+	zeroRatedText     = regexp.MustCompile(`(?i)nultarief|zero[- ]rated`)
+)
+
+// exemptCategories are expense categories that carry no VAT at home.
+var exemptCategories = []string{"insurance", "bank_fees"}
+
+// Classifier classifies the lines of purchase invoices for an administration.
+type Classifier struct {
+	country string
+	rates   *Rates
+}
+
+// NewClassifier returns a classifier for an administration in country, which
+// tells domestic suppliers from foreign ones.
+func NewClassifier(country string, rates *Rates) (*Classifier, error) {
+	if len(country) != 2 {
+		return nil, fmt.Errorf("invalid country code for the administration: %q", country)
+	}
+	return &Classifier{country: strings.ToUpper(country), rates: rates}, nil
+}
+
+// Classify decides the treatment and tax rate of every line.
+func (c *Classifier) Classify(invoice Invoice) []Decision {
+	country := strings.ToUpper(invoice.Country)
+	if country == "" {
+		country = vatCountry(invoice.VatNumber)
+	}
+
+	decisions := make([]Decision, len(invoice.Lines))
+	for i, line := range invoice.Lines {
+		treatment := invoice.Treatment
+		if treatment == "" {
+			var reason string
+			treatment, reason = c.treatment(country, invoice, line)
+			if reason != "" && invoice.ApproveVAT {
+				treatment, reason = c.asExtracted(line)
+			}
+			if reason != "" {
+				decisions[i] = Decision{Reason: fmt.Sprintf("%s: %s", line.Description, reason)}
+				continue
+			}
+		}
+
+		id, reason := c.rates.ID(treatment, line.TaxRate)
+		decisions[i] = Decision{Treatment: treatment, TaxRateID: id}
+		if reason != "" {
+			decisions[i].Reason = fmt.Sprintf("%s: %s", line.Description, reason)
+		}
+	}
+
+	return decisions
+}
+
+// treatment decides the treatment of a line, or explains why it cannot.
+func (c *Classifier) treatment(country string, invoice Invoice, line Line) (Treatment, string) {
+	text := invoice.Note + "\n" + line.Description
+
+	// VAT on the invoice is domestic VAT when the rate is one of ours, also
+	// from foreign suppliers registered here.
+	if line.TaxRate > 0 {
+		if !c.rates.Domestic(line.TaxRate) {
+			return "", fmt.Sprintf("%g%% is not a VAT rate of %s, foreign VAT cannot be booked", line.TaxRate, c.country)
+		}
+		return c.asExtracted(line)
+	}
+
+	if reverseChargeText.MatchString(text) {
+		switch {
+		case country == c.country:
+			return ReverseChargeDomestic, ""
+		case moneybird.IsEUCountry(country):
+			return ReverseChargeEU, ""
+		case country != "":
+			return Import, ""
+		default:
+			return "", "reverse charged by a supplier in an unknown country"
+		}
+	}
+
+	// Exemptions are only recognized at home: suppliers abroad mark
+	// intra-community supplies as exempt too.
+	switch {
+	case country == "":
+		return "", "no VAT charged and the supplier's country is unknown"
+	case country == c.country:
+		if exemptText.MatchString(text) || slices.Contains(exemptCategories, line.Category) {
+			return Exempt, ""
+		}
+		if zeroRatedText.MatchString(text) {
+			return Zero, ""
+		}
+		return "", "no VAT charged by a domestic supplier without a reason on the invoice"
+	case moneybird.IsEUCountry(country):
+		if invoice.VatNumber == "" {
+			return "", fmt.Sprintf("no VAT charged by a supplier in %s without a VAT number", country)
+		}
+		return ReverseChargeEU, ""
+	default:
+		if line.Type == Goods {
+			return "", fmt.Sprintf("goods from %s, VAT is due on import", country)
+		}
+		return Import, ""
+	}
+}
+
+// asExtracted treats a line as domestic VAT at its extracted rate.
+func (c *Classifier) asExtracted(line Line) (Treatment, string) {
+	switch {
+	case line.TaxRate == 0:
+		return Zero, ""
+	case !c.rates.Domestic(line.TaxRate):
+		return "", fmt.Sprintf("no Moneybird tax rate at %g%%", line.TaxRate)
+	case line.TaxRate < c.rates.StandardRate():
+		return Reduced, ""
+	default:
+		return Standard, ""
+	}
+}
+
+// vatCountry returns the country of a VAT number. Greece uses EL.
+func vatCountry(vatNumber string) string {
+	vatNumber = strings.ToUpper(strings.TrimSpace(vatNumber))
+	if len(vatNumber) < 2 || vatNumber[0] < 'A' || vatNumber[0] > 'Z' || vatNumber[1] < 'A' || vatNumber[1] > 'Z' {
+		return ""
+	}
+	if prefix := vatNumber[:2]; prefix != "EL" {
+		return prefix
+	}
+	return "GR"
+}
+
+func samePercentage(a, b float64) bool {
+	return math.Abs(a-b) < 0.001
+}
@@ -0,0 +1,275 @@
+package tax
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/janyksteenbeek/birdgpt/internal/moneybird"
+)
+
+var testPatterns = map[string]string{
+	"reverse_charge_eu":       `binnen (de )?eu|intra|within (the )?eu|icp`,
+	"import":                  `buiten (de )?eu|outside (the )?eu|import`,
+	"reverse_charge_domestic": `verlegd|reverse`,
+	"exempt":                  `geen btw|vrijgesteld|exempt|no vat`,
+	"zero":                    `^0%|nultarief|zero`,
+}
+
+func testClassifier(t *testing.T) *Classifier {
+	t.Helper()
+
+	rates, err := NewRates([]moneybird.TaxRate{
+		{ID: "high", Percentage: 21, Name: "21% btw", TaxRateType: "purchase_invoice"},
+		{ID: "low", Percentage: 9, Name: "9% btw", TaxRateType: "purchase_invoice"},
+		{ID: "zero", Percentage: 0, Name: "0% btw", TaxRateType: "purchase_invoice"},
+		{ID: "eu", Percentage: 0, Name: "Inkoop binnen EU", TaxRateType: "purchase_invoice"},
+		{ID: "import", Percentage: 0, Name: "Inkoop buiten EU", TaxRateType: "purchase_invoice"},
+		{ID: "shifted", Percentage: 0, Name: "BTW verlegd", TaxRateType: "purchase_invoice"},
+		{ID: "exempt", Percentage: 0, Name: "Geen btw", TaxRateType: "purchase_invoice"},
+		{ID: "sales", Percentage: 19, Name: "19% sales", TaxRateType: "sales_invoice"},
+	}, testPatterns)
+	if err != nil {
+		t.Fatalf("NewRates returned error: %v", err)
+	}
+
+	classifier, err := NewClassifier("nl", rates)
+	if err != nil {
+		t.Fatalf("NewClassifier returned error: %v", err)
+	}
+	return classifier
+}
+
+func TestClassify(t *testing.T) {
+	classifier := testClassifier(t)
+
+	tests := []struct {
+		name      string
+		invoice   Invoice
+		treatment Treatment
+		rateID    string
+		// reason is part of the expected review reason, empty when the
+		// line is booked.
+		reason string
+	}{
+		{
+			name:      "domestic standard rate",
+			invoice:   Invoice{Country: "NL", Lines: []Line{{TaxRate: 21}}},
+			treatment: Standard, rateID: "high",
+		},
+		{
+			name:      "domestic reduced rate",
+			invoice:   Invoice{Country: "NL", Lines: []Line{{TaxRate: 9}}},
+			treatment: Reduced, rateID: "low",
+		},
+		{
+			name:      "foreign supplier registered here",
+			invoice:   Invoice{Country: "DE", VatNumber: "DE123456789", Lines: []Line{{TaxRate: 21}}},
+			treatment: Standard, rateID: "high",
+		},
+		{
+			name:    "foreign VAT",
+			invoice: Invoice{Country: "DE", VatNumber: "DE123456789", Lines: []Line{{TaxRate: 19}}},
+			reason:  "19% is not a VAT rate of NL",
+		},
+		{
+			name:      "EU supplier with VAT number",
+			invoice:   Invoice{Country: "DE", VatNumber: "DE123456789", Lines: []Line{{Type: Services}}},
+			treatment: ReverseChargeEU, rateID: "eu",
+		},
+		{
+			name:      "country from VAT number",
+			invoice:   Invoice{VatNumber: "BE0123456789", Lines: []Line{{Type: Goods}}},
+			treatment: ReverseChargeEU, rateID: "eu",
+		},
+		{
+			name:      "Greek VAT number",
+			invoice:   Invoice{VatNumber: "EL123456789", Lines: []Line{{}}},
+			treatment: ReverseChargeEU, rateID: "eu",
+		},
+		{
+			name:    "EU supplier without VAT number",
+			invoice: Invoice{Country: "DE", Lines: []Line{{}}},
+			reason:  "without a VAT number",
+		},
+		{
+			name:      "services from outside the EU",
+			invoice:   Invoice{Country: "US", Lines: []Line{{Type: Services}}},
+			treatment: Import, rateID: "import",
+		},
+		{
+			name:    "goods from outside the EU",
+			invoice: Invoice{Country: "US", Lines: []Line{{Type: Goods}}},
+			reason:  "VAT is due on import",
+		},
+		{
+			name:      "domestic reverse charge",
+			invoice:   Invoice{Country: "NL", Note: "BTW verlegd", Lines: []Line{{}}},
+			treatment: ReverseChargeDomestic, rateID: "shifted",
+		},
+		{
+			name:      "reverse charge noted on an EU invoice",
+			invoice:   Invoice{Country: "FR", Lines: []Line{{Description: "Autoliquidation"}}},
+			treatment: ReverseChargeEU, rateID: "eu",
+		},
+		{
+			name:      "reverse charge from outside the EU",
+			invoice:   Invoice{Country: "GB", Note: "Reverse charge", Lines: []Line{{Type: Goods}}},
+			treatment: Import, rateID: "import",
+		},
+		{
+			name:    "reverse charge from an unknown country",
+			invoice: Invoice{Note: "Reverse charge", Lines: []Line{{}}},
+			reason:  "unknown country",
+		},
+		{
+			name:      "domestic exemption on the invoice",
+			invoice:   Invoice{Country: "NL", Note: "Vrijgesteld van BTW", Lines: []Line{{}}},
+			treatment: Exempt, rateID: "exempt",
+		},
+		{
+			name:      "domestic small business scheme",
+			invoice:   Invoice{Country: "NL", Note: "KOR", Lines: []Line{{}}},
+			treatment: Exempt, rateID: "exempt",
+		},
+		{
+			name:      "domestic insurance",
+			invoice:   Invoice{Country: "NL", Lines: []Line{{Category: "insurance"}}},
+			treatment: Exempt, rateID: "exempt",
+		},
+		{
+			name:      "domestic zero rate",
+			invoice:   Invoice{Country: "NL", Lines: []Line{{Description: "Nultarief"}}},
+			treatment: Zero, rateID: "zero",
+		},
+		{
+			name:    "domestic without VAT or reason",
+			invoice: Invoice{Country: "NL", Lines: []Line{{}}},
+			reason:  "without a reason on the invoice",
+		},
+		{
+			name:    "exemption abroad is not recognized",
+			invoice: Invoice{Country: "DE", Note: "exempt", Lines: []Line{{}}},
+			reason:  "without a VAT number",
+		},
+		{
+			name:    "unknown country",
+			invoice: Invoice{Lines: []Line{{}}},
+			reason:  "country is unknown",
+		},
+		{
+			name:      "pinned treatment",
+			invoice:   Invoice{Country: "US", Treatment: Exempt, Lines: []Line{{Type: Goods}}},
+			treatment: Exempt, rateID: "exempt",
+		},
+		{
+			name:    "pinned treatment without a rate",
+			invoice: Invoice{Country: "NL", Treatment: Standard, Lines: []Line{{TaxRate: 19}}},
+			reason:  "no Moneybird tax rate for standard VAT at 19%",
+		},
+		{
+			name:      "approved VAT at a regular rate",
+			invoice:   Invoice{Country: "NL", ApproveVAT: true, Lines: []Line{{}}},
+			treatment: Zero, rateID: "zero",
+		},
+		{
+			name:    "approved VAT at a foreign rate",
+			invoice: Invoice{Country: "DE", ApproveVAT: true, Lines: []Line{{TaxRate: 19}}},
+			reason:  "no Moneybird tax rate at 19%",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := classifier.Classify(tt.invoice)
+			if len(decisions) != 1 {
+				t.Fatalf("got %d decisions, want 1", len(decisions))
+			}
+
+			got := decisions[0]
+			if tt.reason != "" {
+				if !strings.Contains(got.Reason, tt.reason) {
+					t.Errorf("reason = %q, want it to contain %q", got.Reason, tt.reason)
+				}
+				if got.TaxRateID != "" {
+					t.Errorf("tax rate = %q for an ambiguous line, want none", got.TaxRateID)
+				}
+				return
+			}
+
+			if got.Reason != "" {
+				t.Fatalf("unexpected review: %s", got.Reason)
+			}
+			if got.Treatment != tt.treatment || got.TaxRateID != tt.rateID {
+				t.Errorf("got %s on %q, want %s on %q", got.Treatment, got.TaxRateID, tt.treatment, tt.rateID)
+			}
+		})
+	}
+}
+
+func TestClassifyLinesSeparately(t *testing.T) {
+	classifier := testClassifier(t)
+
+	decisions := classifier.Classify(Invoice{
+		Country: "NL",
+		Lines: []Line{
+			{Description: "Hosting", TaxRate: 21},
+			{Description: "Books", TaxRate: 9},
+			{Description: "Stamps"},
+		},
+	})
+
+	if decisions[0].TaxRateID != "high" || decisions[1].TaxRateID != "low" {
+		t.Errorf("got tax rates %q and %q, want high and low", decisions[0].TaxRateID, decisions[1].TaxRateID)
+	}
+	if !strings.HasPrefix(decisions[2].Reason, "Stamps: ") {
+		t.Errorf("reason = %q, want it to name the line", decisions[2].Reason)
+	}
+}
+
+func TestRatesAmbiguous(t *testing.T) {
+	rates, err := NewRates([]moneybird.TaxRate{
+		{ID: "a", Percentage: 21, Name: "21% btw"},
+		{ID: "b", Percentage: 21, Name: "21% btw (old)"},
+		{ID: "c", Percentage: 0, Name: "Binnen EU"},
+		{ID: "d", Percentage: 0, Name: "ICP levering"},
+	}, testPatterns)
+	if err != nil {
+		t.Fatalf("NewRates returned error: %v", err)
+	}
+
+	tests := []struct {
+		treatment Treatment
+		rate      float64
+		reason    string
+	}{
+		{Standard, 21, "several Moneybird tax rates for standard: 21% btw, 21% btw (old)"},
+		{ReverseChargeEU, 0, "several Moneybird tax rates for reverse_charge_eu"},
+		{Import, 0, "no Moneybird tax rate for import"},
+		{Reduced, 9, "no Moneybird tax rate for reduced VAT at 9%"},
+	}
+
+	for _, tt := range tests {
+		id, reason := rates.ID(tt.treatment, tt.rate)
+		if id != "" || !strings.Contains(reason, tt.reason) {
+			t.Errorf("ID(%s, %g) = %q, %q, want reason %q", tt.treatment, tt.rate, id, reason, tt.reason)
+		}
+	}
+}
+
+func TestNewRatesInvalidPatterns(t *testing.T) {
+	for _, patterns := range []map[string]string{
+		{"standard": "hoog"},
+		{"unknown": "x"},
+		{"exempt": "("},
+	} {
+		if _, err := NewRates(nil, patterns); err == nil {
+			t.Errorf("NewRates with %v returned no error", patterns)
+		}
+	}
+}
+
+func TestNewClassifierRequiresCountry(t *testing.T) {
+	if _, err := NewClassifier("", &Rates{}); err == nil {
+		t.Error("NewClassifier without a country returned no error")
+	}
+}